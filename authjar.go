@@ -0,0 +1,88 @@
+package httpdigest
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// AuthJar stores credentials by URL, the way http.CookieJar stores cookies.
+// When a Transport has a Jar, it asks it for the credentials of each request
+// and only falls back to Username and Password if the jar has none.
+//
+// Implementations must be safe for concurrent use.
+type AuthJar interface {
+	// SetCredentials stores creds for u and every URL below its path.
+	SetCredentials(u *url.URL, creds Credentials)
+	// Credentials returns the credentials to use for a request to u.
+	Credentials(u *url.URL) (creds Credentials, ok bool)
+}
+
+// MemoryAuthJar is an in-memory AuthJar. Entries are scoped by scheme, host
+// (including the port) and path prefix; the longest matching prefix wins.
+// The zero value is an empty jar ready to use.
+type MemoryAuthJar struct {
+	mu      sync.RWMutex
+	entries map[string]map[string]Credentials
+}
+
+// NewMemoryAuthJar returns an empty MemoryAuthJar.
+func NewMemoryAuthJar() *MemoryAuthJar {
+	return &MemoryAuthJar{
+		entries: make(map[string]map[string]Credentials),
+	}
+}
+
+// SetCredentials implements AuthJar.
+func (j *MemoryAuthJar) SetCredentials(u *url.URL, creds Credentials) {
+	root := jarRoot(u)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.entries == nil {
+		j.entries = make(map[string]map[string]Credentials)
+	}
+	paths := j.entries[root]
+	if paths == nil {
+		paths = make(map[string]Credentials)
+		j.entries[root] = paths
+	}
+	paths[jarPath(u)] = creds
+}
+
+// Credentials implements AuthJar.
+func (j *MemoryAuthJar) Credentials(u *url.URL) (Credentials, bool) {
+	p := jarPath(u)
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	var (
+		best  Credentials
+		blen  = -1
+		paths = j.entries[jarRoot(u)]
+	)
+	for prefix, creds := range paths {
+		if len(prefix) > blen && pathMatch(prefix, p) {
+			best, blen = creds, len(prefix)
+		}
+	}
+	return best, blen >= 0
+}
+
+func jarRoot(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + canonicalHost(u.Host)
+}
+
+func jarPath(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// pathMatch reports whether p is below prefix, matching whole path segments
+// ("/a" matches "/a" and "/a/b" but not "/ab").
+func pathMatch(prefix, p string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
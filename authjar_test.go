@@ -0,0 +1,68 @@
+package httpdigest
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryAuthJar(t *testing.T) {
+	jar := NewMemoryAuthJar()
+	mustParse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		assert.NoError(t, err)
+		return u
+	}
+	jar.SetCredentials(mustParse("http://Device.local:8080"), Credentials{Username: "root", Password: "1"})
+	jar.SetCredentials(mustParse("http://device.local:8080/admin"), Credentials{Username: "admin", Password: "2"})
+
+	tests := []struct {
+		url  string
+		user string
+		ok   bool
+	}{
+		{"http://device.local:8080/", "root", true},
+		{"http://device.local:8080/administrator", "root", true},
+		{"http://device.local:8080/admin", "admin", true},
+		{"http://DEVICE.local:8080/admin/users?id=1", "admin", true},
+		{"https://device.local:8080/admin", "", false},
+		{"http://device.local/admin", "", false},
+	}
+	for _, tt := range tests {
+		creds, ok := jar.Credentials(mustParse(tt.url))
+		assert.Equal(t, tt.ok, ok, tt.url)
+		assert.Equal(t, tt.user, creds.Username, tt.url)
+	}
+}
+
+func TestMemoryAuthJarZeroValue(t *testing.T) {
+	var jar MemoryAuthJar
+	u, _ := url.Parse("http://device.local/")
+	_, ok := jar.Credentials(u)
+	assert.False(t, ok)
+	jar.SetCredentials(u, Credentials{Username: "root"})
+	creds, ok := jar.Credentials(u)
+	assert.True(t, ok)
+	assert.Equal(t, "root", creds.Username)
+}
+
+func TestTransportJar(t *testing.T) {
+	ts := newTestServer(t)
+	u, _ := url.Parse(ts.URL + "/private")
+	jar := NewMemoryAuthJar()
+	jar.SetCredentials(u, Credentials{Username: "john", Password: "doe"})
+	tr := New("nobody", "none")
+	tr.Jar = jar
+
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/private/a"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/public"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
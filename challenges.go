@@ -0,0 +1,342 @@
+package httpdigest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// challengeCache holds the digest challenges known for each protection
+// space. Challenges are grouped by scheme and host, and each one only applies
+// to the paths of its domain directive (RFC 7616 section 3.3), or to the
+// whole host if it has none. The zero value is ready to use.
+//
+// The cache also learns how long the nonces of each server stay valid: when
+// a cached challenge is reported stale, its age is taken as the lifetime of
+// the server's nonces, and challenges older than that are no longer handed
+// out, so a fresh handshake replaces them before the server rejects them.
+type challengeCache struct {
+	mu        sync.Mutex
+	m         map[string][]cachedChallenge
+	lifetimes map[string]time.Duration
+	disabled  bool
+}
+
+type cachedChallenge struct {
+	wwwa   *WWWAuth
+	issued time.Time
+	// domain holds the path prefixes the challenge applies to, nil for the
+	// whole host
+	domain []string
+}
+
+// maxChallengesPerHost bounds the protection spaces remembered for a host.
+const maxChallengesPerHost = 8
+
+// lifetimeMargin is the part of the learned nonce lifetime after which a
+// challenge is considered expired.
+const lifetimeMargin = 0.9
+
+// find returns the index of the challenge that applies to u among those of
+// its host, preferring the longest matching domain, or -1.
+func (c *challengeCache) find(key string, u *url.URL) int {
+	best, bestLen := -1, -1
+	for i, cc := range c.m[key] {
+		if cc.domain == nil && bestLen < 0 {
+			best, bestLen = i, 0
+		}
+		for _, prefix := range cc.domain {
+			if pathMatch(prefix, jarPath(u)) && len(prefix) > bestLen {
+				best, bestLen = i, len(prefix)
+			}
+		}
+	}
+	return best
+}
+
+func (c *challengeCache) remove(key string, i int) {
+	entries := c.m[key]
+	c.m[key] = append(entries[:i:i], entries[i+1:]...)
+}
+
+func (c *challengeCache) get(u *url.URL) *WWWAuth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabled {
+		return nil
+	}
+	key := jarRoot(u)
+	i := c.find(key, u)
+	if i < 0 {
+		return nil
+	}
+	cc := c.m[key][i]
+	if lt, ok := c.lifetimes[key]; ok && timeNow().Sub(cc.issued) >= time.Duration(float64(lt)*lifetimeMargin) {
+		c.remove(key, i)
+		return nil
+	}
+	return cc.wwwa
+}
+
+func (c *challengeCache) put(u *url.URL, wwwa *WWWAuth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabled {
+		return
+	}
+	if c.m == nil {
+		c.m = make(map[string][]cachedChallenge)
+	}
+	key := jarRoot(u)
+	cc := cachedChallenge{wwwa: wwwa, issued: timeNow(), domain: challengeDomain(u, wwwa)}
+	entries := c.m[key]
+	for i, e := range entries {
+		if e.wwwa.Realm == wwwa.Realm {
+			entries[i] = cc
+			return
+		}
+	}
+	if len(entries) >= maxChallengesPerHost {
+		entries = entries[1:]
+	}
+	c.m[key] = append(entries, cc)
+}
+
+func (c *challengeCache) delete(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := jarRoot(u)
+	if i := c.find(key, u); i >= 0 {
+		c.remove(key, i)
+	}
+}
+
+// rotate replaces the nonce of the cached challenge of u with nonce, the
+// nextnonce announced by the server, and restarts its age.
+func (c *challengeCache) rotate(u *url.URL, nonce string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := jarRoot(u)
+	i := c.find(key, u)
+	if i < 0 {
+		return
+	}
+	cc := &c.m[key][i]
+	wwwa := *cc.wwwa
+	wwwa.Nonce = nonce
+	cc.wwwa, cc.issued = &wwwa, timeNow()
+}
+
+// stale records that the server rejected the cached challenge of u as stale,
+// and updates the nonce lifetime learned for the server.
+func (c *challengeCache) stale(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := jarRoot(u)
+	i := c.find(key, u)
+	if i < 0 {
+		return
+	}
+	age := timeNow().Sub(c.m[key][i].issued)
+	c.remove(key, i)
+	if c.lifetimes == nil {
+		c.lifetimes = make(map[string]time.Duration)
+	}
+	if lt, ok := c.lifetimes[key]; ok {
+		// average with the previous observations
+		age = (lt + age) / 2
+	}
+	c.lifetimes[key] = age
+}
+
+// challengeDomain returns the path prefixes of the domain directive of wwwa,
+// received in response to a request to u, or nil if the challenge applies to
+// the whole host. Absolute URIs of other hosts are ignored: the credentials
+// are only offered to the host that asked for them.
+func challengeDomain(u *url.URL, wwwa *WWWAuth) []string {
+	fields := strings.Fields(wwwa.Domain)
+	if len(fields) == 0 {
+		return nil
+	}
+	domain := make([]string, 0, len(fields))
+	for _, f := range fields {
+		d, err := u.Parse(f)
+		if err != nil || jarRoot(d) != jarRoot(u) {
+			continue
+		}
+		domain = append(domain, jarPath(d))
+	}
+	return domain
+}
+
+func (c *challengeCache) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.disabled
+}
+
+// setEnabled turns the cache on or off. Disabling it also empties it.
+func (c *challengeCache) setEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disabled = !enabled
+	if !enabled {
+		c.m = nil
+	}
+}
+
+// handshakeGroup coalesces the handshakes with a host whose challenge is not
+// known yet: while one request goes through the handshake, the others wait
+// for the challenge it receives, and are then signed preemptively. The zero
+// value is ready to use.
+type handshakeGroup struct {
+	mu sync.Mutex
+	m  map[string]*handshake
+}
+
+// handshake is a handshake in progress with a host.
+type handshake struct {
+	ready chan struct{}
+	u     *url.URL
+	// wwwa is the digest challenge received by the handshake, set before
+	// ready is closed, or nil if there was none
+	wwwa *WWWAuth
+}
+
+// join returns the handshake in progress with the server of u, or nil if
+// there is none. In that case the caller goes through the handshake and must
+// call done as soon as it knows the challenge of the server.
+func (g *handshakeGroup) join(u *url.URL) *handshake {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil {
+		g.m = make(map[string]*handshake)
+	}
+	key := jarRoot(u)
+	if h, ok := g.m[key]; ok {
+		return h
+	}
+	g.m[key] = &handshake{ready: make(chan struct{}), u: u}
+	return nil
+}
+
+// done ends the handshake with the server of u and hands wwwa, which may be
+// nil, to the requests waiting for it.
+func (g *handshakeGroup) done(u *url.URL, wwwa *WWWAuth) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := jarRoot(u)
+	h := g.m[key]
+	h.wwwa = wwwa
+	close(h.ready)
+	delete(g.m, key)
+}
+
+// challenge returns the challenge received by the handshake if it applies to
+// u, once the handshake is over.
+func (h *handshake) challenge(u *url.URL) *WWWAuth {
+	if h.wwwa == nil {
+		return nil
+	}
+	domain := challengeDomain(h.u, h.wwwa)
+	if domain == nil {
+		return h.wwwa
+	}
+	for _, prefix := range domain {
+		if pathMatch(prefix, jarPath(u)) {
+			return h.wwwa
+		}
+	}
+	return nil
+}
+
+// learnChallenge stores the digest challenge attached to a response that
+// didn't ask for authentication, when LearnChallenges is set.
+func (t *Transport) learnChallenge(resp *http.Response) {
+	if !t.LearnChallenges || resp.Request == nil {
+		return
+	}
+	if wwwa := bestDigestChallenge(t.challengeValues(resp.Header), t.algorithmRank); wwwa != nil {
+		t.challenges.put(t.spaceURL(resp.Request), wwwa)
+	}
+}
+
+// rememberChallenge keeps the digest challenge of h, the headers of the 401
+// response to req, once it has been answered with authh successfully, so the
+// next requests to the host are signed without waiting for a 401.
+func (t *Transport) rememberChallenge(req *http.Request, h http.Header, authh string) {
+	if t.DisablePreemptive || !isScheme(authh, "Digest") {
+		return
+	}
+	if wwwa := bestDigestChallenge(t.challengeValues(h), t.algorithmRank); wwwa != nil {
+		t.challenges.put(t.spaceURL(req), wwwa)
+	}
+}
+
+// consumeNextNonce rotates the cached challenge of the request of resp to
+// the nextnonce of its Authentication-Info header, or Proxy-Authentication-Info
+// in Proxy mode (RFC 7616 section 3.5), so the next request is signed with
+// the nonce the server expects.
+func (t *Transport) consumeNextNonce(resp *http.Response) {
+	if resp.Request == nil {
+		return
+	}
+	info := resp.Header.Get(t.authenticationInfoHeader())
+	if info == "" {
+		return
+	}
+	if nonce := parseParams(info)["nextnonce"]; nonce != "" {
+		t.challenges.rotate(t.spaceURL(resp.Request), nonce)
+	}
+}
+
+// staleRequest returns a copy of req2, the authenticated request, signed
+// with the new nonce of resp, its response, when the server rejected the
+// request only because the nonce was stale. It returns nil otherwise.
+func (t *Transport) staleRequest(req, req2 *http.Request, resp *http.Response, creds Credentials, quirks Quirks) *http.Request {
+	if !t.isChallenge(resp) {
+		return nil
+	}
+	wwwa := bestDigestChallenge(t.challengeValues(resp.Header), t.algorithmRank)
+	if wwwa == nil || !strings.EqualFold(wwwa.Stale, "true") {
+		return nil
+	}
+	authh, err := t.digest(req, wwwa, creds, quirks)
+	if err != nil {
+		return nil
+	}
+	req3, err := rewindBody(req2)
+	if err != nil {
+		return nil
+	}
+	req3.Header.Set(t.authorizationHeader(), authh)
+	return req3
+}
+
+// rejectChallenge handles a challenge received in response to a request
+// signed with a cached challenge. If the server reports the nonce as stale,
+// the new challenge replaces the cached one; otherwise the cached one is
+// dropped.
+func (t *Transport) rejectChallenge(req *http.Request, resp *http.Response) {
+	space := t.spaceURL(req)
+	wwwa := bestDigestChallenge(t.challengeValues(resp.Header), t.algorithmRank)
+	if wwwa == nil || !strings.EqualFold(wwwa.Stale, "true") {
+		t.challenges.delete(space)
+		return
+	}
+	t.challenges.stale(space)
+	t.challenges.put(space, wwwa)
+}
+
+// EnableCache turns the use of known challenges on or off at runtime. While
+// disabled, every request goes through a fresh handshake, which helps when
+// debugging nonce problems with a device. Disabling the cache forgets the
+// challenges it holds. The cache is enabled by default.
+func (t *Transport) EnableCache(enabled bool) {
+	t.challenges.setEnabled(enabled)
+}
@@ -0,0 +1,254 @@
+package httpdigest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lifetime returns the nonce lifetime learned for the server of u.
+func (c *challengeCache) lifetime(u *url.URL) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lt, ok := c.lifetimes[jarRoot(u)]
+	return lt, ok
+}
+
+func TestChallengeCacheLifetime(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	var c challengeCache
+	u, _ := url.Parse("http://device.local/a")
+	c.put(u, &WWWAuth{Nonce: "1"})
+	now = now.Add(time.Hour)
+	assert.NotNil(t, c.get(u))
+	c.stale(u)
+	assert.Nil(t, c.get(u))
+	lt, ok := c.lifetime(u)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, lt)
+
+	c.put(u, &WWWAuth{Nonce: "2"})
+	now = now.Add(50 * time.Minute)
+	assert.NotNil(t, c.get(u))
+	now = now.Add(5 * time.Minute)
+	// past 90% of the learned lifetime
+	assert.Nil(t, c.get(u))
+
+	c.put(u, &WWWAuth{Nonce: "3"})
+	now = now.Add(30 * time.Minute)
+	c.stale(u)
+	lt, _ = c.lifetime(u)
+	assert.Equal(t, 45*time.Minute, lt)
+}
+
+func TestChallengeCacheDomain(t *testing.T) {
+	var c challengeCache
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	api := &WWWAuth{Realm: "api", Domain: "/api/ http://device.local/cgi-bin http://other.local/"}
+	c.put(parse("http://device.local/api/login"), api)
+	assert.Equal(t, api, c.get(parse("http://device.local/api/status")))
+	assert.Equal(t, api, c.get(parse("http://device.local/cgi-bin/x.cgi")))
+	assert.Nil(t, c.get(parse("http://device.local/cgi-binary")))
+	assert.Nil(t, c.get(parse("http://device.local/")))
+	assert.Nil(t, c.get(parse("http://other.local/")))
+
+	site := &WWWAuth{Realm: "site"}
+	c.put(parse("http://device.local/"), site)
+	assert.Equal(t, site, c.get(parse("http://device.local/index.html")))
+	assert.Equal(t, api, c.get(parse("http://device.local/api/status")))
+
+	c.delete(parse("http://device.local/api/status"))
+	assert.Equal(t, site, c.get(parse("http://device.local/api/status")))
+}
+
+func TestNonceCounter(t *testing.T) {
+	var c nonceCounter
+	a, _ := url.Parse("http://a.local/")
+	b, _ := url.Parse("http://b.local/")
+	assert.Equal(t, uint(1), c.next(a, "n1"))
+	assert.Equal(t, uint(2), c.next(a, "n1"))
+	assert.Equal(t, uint(1), c.next(b, "n1"))
+	assert.Equal(t, uint(1), c.next(a, "n2"))
+	assert.Equal(t, uint(3), c.next(a, "n1"))
+	for i := 0; i < maxNoncesPerHost; i++ {
+		c.next(a, fmt.Sprint("other", i))
+	}
+	// forgotten
+	assert.Equal(t, uint(1), c.next(a, "n1"))
+}
+
+func TestNonceCounterConcurrent(t *testing.T) {
+	var c nonceCounter
+	u, _ := url.Parse("http://a.local/")
+	const n = 50
+	ncs := make(chan uint, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ncs <- c.next(u, "n1")
+		}()
+	}
+	wg.Wait()
+	close(ncs)
+	seen := make(map[uint]bool)
+	for nc := range ncs {
+		assert.False(t, seen[nc], "nc %d handed out twice", nc)
+		seen[nc] = true
+	}
+	assert.Len(t, seen, n)
+	assert.Equal(t, uint(n+1), c.next(u, "n1"))
+}
+
+func TestTransportPreemptive(t *testing.T) {
+	ts := newTestServer(t)
+	ts.StrictNC = true
+	tr := New("john", "doe")
+	get := func() int {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, http.StatusOK, get())
+	reqs := ts.Requests()
+	if assert.Len(t, reqs, 3) {
+		assert.Equal(t, "00000002", parseDigest(reqs[2].Header.Get("Authorization"))["nc"])
+	}
+	assert.Equal(t, uint64(1), tr.Stats().Preemptive)
+
+	// a new nonce makes the server reject the preemptive request
+	ts.mu.Lock()
+	ts.Nonce = "0a4f113b"
+	ts.mu.Unlock()
+	assert.Equal(t, http.StatusOK, get())
+	assert.Len(t, ts.Requests(), 5)
+	assert.Equal(t, uint64(1), tr.Stats().PreemptiveRejected)
+	assert.Equal(t, http.StatusOK, get())
+	assert.Len(t, ts.Requests(), 6)
+
+	tr = New("john", "doe")
+	tr.DisablePreemptive = true
+	get()
+	get()
+	assert.Len(t, ts.Requests(), 10)
+}
+
+func TestTransportNextNonce(t *testing.T) {
+	ts := newTestServer(t)
+	ts.StrictNC = true
+	served := 0
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, r)
+		ts.mu.Unlock()
+		if !ts.verify(r, nil) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop="auth",realm=%q,nonce=%q`, ts.Realm, ts.Nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the server hands out a new nonce with every response
+		served++
+		ts.mu.Lock()
+		ts.Nonce = fmt.Sprint("next", served)
+		ts.mu.Unlock()
+		w.Header().Set("Authentication-Info", fmt.Sprintf(`qop=auth, nextnonce=%q`, ts.Nonce))
+	})
+	tr := New("john", "doe")
+	for i := 0; i < 3; i++ {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	reqs := ts.Requests()
+	if assert.Len(t, reqs, 4) {
+		p := parseDigest(reqs[3].Header.Get("Authorization"))
+		assert.Equal(t, "next2", p["nonce"])
+		assert.Equal(t, "00000001", p["nc"])
+	}
+	assert.Equal(t, Stats{Requests: 3, Challenges: 1, Preemptive: 2}, tr.Stats())
+}
+
+func TestTransportCoalescedHandshake(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			// let the other requests pile up
+			time.Sleep(50 * time.Millisecond)
+		}
+		base.ServeHTTP(w, r)
+	})
+	tr := New("john", "doe")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	probes := 0
+	for _, r := range ts.Requests() {
+		if r.Header.Get("Authorization") == "" {
+			probes++
+		}
+	}
+	assert.Equal(t, 1, probes)
+	assert.Len(t, ts.Requests(), 6)
+}
+
+func TestTransportCoalescedHandshakeSlowLeader(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	probed := make(chan struct{}, 1)
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			probed <- struct{}{}
+			// let the other request join the handshake
+			time.Sleep(50 * time.Millisecond)
+		} else if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		}
+		base.ServeHTTP(w, r)
+	})
+	tr := New("john", "doe")
+	leader := make(chan struct{})
+	go func() {
+		defer close(leader)
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/slow"))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}()
+	<-probed
+	start := time.Now()
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	// the follower doesn't wait for the authenticated request of the leader
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	<-leader
+	assert.Len(t, ts.Requests(), 3)
+}
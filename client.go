@@ -0,0 +1,41 @@
+package httpdigest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultClientTimeout is the timeout of the clients created by NewClient. It
+// covers both round trips of the digest handshake.
+var DefaultClientTimeout = 30 * time.Second
+
+// maxRedirects mirrors the limit of the default http.Client policy.
+const maxRedirects = 10
+
+// NewClient returns an HTTP client that authenticates with the given
+// credentials. Compared to Transport.Client, the client has a timeout
+// (DefaultClientTimeout) and only follows redirects that stay on the same
+// host and scheme, so the credentials are never offered to another server;
+// for other redirects the 3xx response is returned as is.
+func NewClient(username, password string, opts ...Option) (*http.Client, error) {
+	cl, err := New(username, password, opts...).Client()
+	if err != nil {
+		return nil, err
+	}
+	cl.Timeout = DefaultClientTimeout
+	cl.CheckRedirect = checkRedirect
+	return cl, nil
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	orig := via[0].URL
+	if !strings.EqualFold(req.URL.Host, orig.Host) || req.URL.Scheme != orig.Scheme {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package httpdigest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClient(t *testing.T) {
+	ts := newTestServer(t)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("redirect to another host was followed")
+	}))
+	defer other.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/", ts.Config.Handler)
+	mux.HandleFunc("/same", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/target", http.StatusFound)
+	})
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/target", http.StatusFound)
+	})
+	ts.Config.Handler = mux
+
+	cl, err := NewClient("john", "doe")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultClientTimeout, cl.Timeout)
+
+	resp, err := cl.Get(ts.URL + "/same")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/target", resp.Request.URL.Path)
+
+	resp, err = cl.Get(ts.URL + "/other")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	_, err = NewClient("john", "doe", func(t *Transport) { t.Transport = nil })
+	assert.Error(t, err)
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	var newAuth []string
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" && ts.verify(r, nil) {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/new" {
+			newAuth = append(newAuth, r.Header.Get("Authorization"))
+		}
+		base.ServeHTTP(w, r)
+	})
+
+	tests := []struct {
+		policy RedirectPolicy
+		status int
+		reused bool
+	}{
+		{RedirectResign, http.StatusOK, false},
+		{RedirectReuse, http.StatusOK, true},
+		{RedirectDrop, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		newAuth = nil
+		cl, err := NewClient("john", "doe", func(t *Transport) { t.RedirectPolicy = tt.policy })
+		assert.NoError(t, err)
+		resp, err := cl.Get(ts.URL + "/old")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, tt.status, resp.StatusCode)
+		assert.Equal(t, tt.reused, strings.Contains(newAuth[0], `uri="/old"`))
+	}
+}
+
+func TestSameHostRedirect(t *testing.T) {
+	redirect := func(from, to string) *http.Request {
+		req := mustRequest(t, http.MethodGet, to)
+		req.Response = &http.Response{Request: mustRequest(t, http.MethodGet, from)}
+		return req
+	}
+	// equivalent spellings of a host are the same host
+	assert.True(t, sameHostRedirect(redirect("http://MÜNCHEN.de/old", "http://xn--mnchen-3ya.de/new")))
+	assert.True(t, sameHostRedirect(redirect("http://[0:0::1]:8080/old", "http://[::1]:8080/new")))
+	assert.False(t, crossHostRedirect(redirect("http://Example.COM/old", "http://example.com/new")))
+	assert.True(t, crossHostRedirect(redirect("http://example.com/old", "http://example.com:8080/new")))
+}
+
+func TestCrossHostRedirect(t *testing.T) {
+	other := newTestServer(t)
+	ts := httptest.NewServer(http.RedirectHandler(other.URL+"/target", http.StatusFound))
+	defer ts.Close()
+
+	tr := New("john", "doe")
+	tr.AuthorizationHeader = "X-Authorization"
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	// http.Client copies custom headers to the redirected request
+	req.Header.Set("X-Authorization", "Digest caller")
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	reqs := other.Requests()
+	if assert.Len(t, reqs, 1) {
+		assert.Empty(t, reqs[0].Header.Get("X-Authorization"))
+		assert.Empty(t, reqs[0].Header.Get("Authorization"))
+	}
+
+	tr = New("john", "doe")
+	tr.AuthenticateCrossHostRedirects = true
+	resp, err = (&http.Client{Transport: tr}).Get(ts.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
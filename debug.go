@@ -0,0 +1,61 @@
+package httpdigest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+)
+
+type debugKey struct{}
+
+// WithDebug returns a context that enables the debug dumps for the requests
+// made with it, as Debug does for every request.
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey{}, true)
+}
+
+func debugging(ctx context.Context) bool {
+	return Debug || ctx.Value(debugKey{}) != nil
+}
+
+func (t *Transport) dumpRequest(req *http.Request) {
+	if !debugging(req.Context()) {
+		return
+	}
+	dump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		t.logError("dump request error", err)
+		return
+	}
+	t.logDump("dump request", dump)
+}
+
+func (t *Transport) dumpResponse(ctx context.Context, resp *http.Response) {
+	if !debugging(ctx) {
+		return
+	}
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.logError("dump response error", err)
+		return
+	}
+	t.logDump("dump response", dump)
+}
+
+func (t *Transport) logDump(title string, dump []byte) {
+	if t.Logger != nil {
+		t.Logger.Printf("%s: \n%s\n", title, dump)
+		return
+	}
+	fmt.Printf("%s: \n%s\n\n\n", title, dump)
+}
+
+func (t *Transport) logError(msg string, err error) {
+	if t.Logger != nil {
+		t.Logger.Println(msg, err)
+		return
+	}
+	log.Println(msg, err)
+}
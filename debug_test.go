@@ -0,0 +1,32 @@
+package httpdigest
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDebug(t *testing.T) {
+	ts := newTestServer(t)
+	var buf bytes.Buffer
+	tr := New("john", "doe")
+	tr.Logger = log.New(&buf, "", 0)
+	tr.DisablePreemptive = true
+
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, buf.String())
+
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	resp, err = tr.RoundTrip(req.WithContext(WithDebug(req.Context())))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, strings.Count(buf.String(), "dump request:"))
+	assert.Equal(t, 2, strings.Count(buf.String(), "dump response:"))
+	assert.Contains(t, buf.String(), "Authorization: Digest")
+}
@@ -2,7 +2,11 @@ package httpdigest
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
+	"hash"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,14 +19,32 @@ type WWWAuth struct {
 	Stale     string
 	Algorithm string
 	Qop       string
+	// Userhash is "true" when the server wants the username hashed (RFC
+	// 7616 section 3.4.4).
+	Userhash string
+	// Charset is "UTF-8" when the server announces that it expects the
+	// username and password encoded in UTF-8, which is how they are always
+	// hashed. Non-ASCII usernames are then sent with the username*
+	// parameter, and as they are in a quoted-string otherwise.
+	Charset string
+	// Params holds every parameter of the challenge by lower case name,
+	// including the vendor extensions that have no field of their own.
+	Params map[string]string
 }
 
+// ParseWWWAuthenticate parses a digest challenge. The header value may hold a
+// list of challenges (`Basic realm="a", Digest realm="a", nonce="b"`), in
+// which case the first digest challenge is parsed.
 func ParseWWWAuthenticate(entry string) (wwwa *WWWAuth, err error) {
 	entry = strings.TrimSpace(entry)
-	if !strings.HasPrefix(entry, "Digest ") {
-		return nil, fmt.Errorf("bad challenge '%s'", entry)
+	if c := findChallenge(splitChallenges([]string{entry}), "Digest"); c != "" {
+		entry = c
 	}
-	dkeys := parseDigest(entry)
+	if !isScheme(entry, "Digest") || len(entry) == len("Digest") {
+		// a digest challenge without parameters has no nonce to answer
+		return nil, fmt.Errorf("%w '%s'", ErrBadChallenge, entry)
+	}
+	_, dkeys := ParseAuthParams(entry)
 	wwwa = &WWWAuth{
 		Realm:     dkeys["realm"],
 		Domain:    dkeys["domain"],
@@ -31,14 +53,22 @@ func ParseWWWAuthenticate(entry string) (wwwa *WWWAuth, err error) {
 		Stale:     dkeys["stale"],
 		Algorithm: dkeys["algorithm"],
 		Qop:       dkeys["qop"],
+		Userhash:  dkeys["userhash"],
+		Charset:   dkeys["charset"],
+		Params:    dkeys,
 	}
 	//TODO: catch bad algorithm
 	return wwwa, nil
 }
 
 type DigestInput struct {
-	Username  string
-	Password  string
+	Username string
+	Password string
+	// HA1, if set, is used instead of hashing Username, the realm and
+	// Password.
+	HA1 string
+	// Secret, if set, holds the password instead of Password.
+	Secret    *Secret
 	DigestURI string
 	// nonce-count
 	// The nc-value is the hexadecimal
@@ -52,72 +82,248 @@ type DigestInput struct {
 	NonceCount uint
 	Cnonce     string
 	Method     string
+	// Quirks adjusts the generated header for servers that deviate from the
+	// RFC.
+	Quirks Quirks
+	// Qop is the quality of protection to answer with, "auth" or "auth-int",
+	// if the challenge offers it. Otherwise "auth" is preferred.
+	Qop string
+	// Body is the entity body of the request, hashed into the response when
+	// the qop is "auth-int".
+	Body []byte
+	// ExtraParams are vendor specific parameters appended to the header, as
+	// quoted strings, in the order of their names.
+	ExtraParams map[string]string
 }
 
 func (a *WWWAuth) Digest(inp DigestInput) (auth string, err error) {
 	if inp.NonceCount == 0 {
 		inp.NonceCount++
 	}
+	if inp.Quirks.ForceMD5 {
+		a2 := *a
+		a2.Algorithm = AlgorithmMD5
+		a = &a2
+	}
+	switch {
+	case (inp.Qop == "auth" || inp.Qop == "auth-int") && a.offersQop(inp.Qop):
+		return a.digestAuth(inp, inp.Qop)
+	case a.offersQop("auth"):
+		return a.digestAuth(inp, "auth")
+	case a.offersQop("auth-int"):
+		return a.digestAuth(inp, "auth-int")
+	}
+	return "", fmt.Errorf("%w ('%s')", ErrUnsupportedQop, a.Qop)
+}
+
+// offersQop reports whether qop is one of the qop values of the challenge.
+func (a *WWWAuth) offersQop(qop string) bool {
 	// Qop may be separated by comma because the server can support more than one
 	// implementation
-	qopsplit := strings.Split(a.Qop, ",")
-	for _, qop := range qopsplit {
-		switch qop {
-		case "auth":
-			return a.digestAuth(inp)
+	for _, q := range strings.Split(a.Qop, ",") {
+		if strings.TrimSpace(q) == qop {
+			return true
 		}
 	}
-	return "", fmt.Errorf("digest not implemented ('%s')", a.Qop)
+	return false
 }
 
-func (a *WWWAuth) digestAuth(inp DigestInput) (auth string, err error) {
-
-	h1, err := a.ha1(inp)
+func (a *WWWAuth) digestAuth(inp DigestInput, qop string) (auth string, err error) {
+	if inp.Cnonce == "" {
+		inp.Cnonce = newCnonce()
+	}
+	cnonce := inp.Cnonce
+	hash, err := a.hash()
 	if err != nil {
 		return "", err
 	}
-	h2 := md5hex("%s:%s", inp.Method, inp.DigestURI)
-	cnonce := inp.Cnonce
-	if cnonce == "" {
-		cnonce = newCnonce()
+	if inp.HA1 != "" && len(inp.HA1) != len(hash("")) {
+		return "", fmt.Errorf("%w ('%s' with an HA1 of another algorithm)", ErrUnsupportedAlgorithm, a.Algorithm)
+	}
+	h1 := a.ha1(hash, inp)
+	h2 := hash("%s:%s", inp.Method, inp.DigestURI)
+	if qop == "auth-int" {
+		h2 = hash("%s:%s:%s", inp.Method, inp.DigestURI, hash("%s", inp.Body))
+	}
+	response := hash("%s:%s:%08x:%s:%s:%s", h1, a.Nonce, inp.NonceCount, cnonce, qop, h2)
+
+	username := inp.Username
+	userhash := strings.EqualFold(a.Userhash, "true")
+	if userhash {
+		username = hash("%s:%s", inp.Username, a.Realm)
 	}
-	response := md5hex("%s:%s:%08x:%s:%s:%s", h1, a.Nonce, inp.NonceCount, cnonce, "auth", h2)
 
 	rvs := make([]string, 0)
-	rvs = append(rvs, fmt.Sprintf("username=%v", strconv.Quote(inp.Username)))
+	if hasControl(username) || (!isASCII(username) && strings.EqualFold(a.Charset, "UTF-8")) {
+		// a quoted-string can't carry it, or the server decodes username*
+		// (RFC 7616 section 3.4.4)
+		rvs = append(rvs, "username*="+encodeExtValue(username))
+	} else {
+		rvs = append(rvs, fmt.Sprintf("username=%v", strconv.Quote(username)))
+	}
 	rvs = append(rvs, fmt.Sprintf("realm=%v", strconv.Quote(a.Realm)))
 	rvs = append(rvs, fmt.Sprintf("nonce=%v", strconv.Quote(a.Nonce))) //TODO: ommit of no nonce
 	rvs = append(rvs, fmt.Sprintf("uri=%v", strconv.Quote(inp.DigestURI)))
 	rvs = append(rvs, fmt.Sprintf("cnonce=%v", strconv.Quote(cnonce)))
 	rvs = append(rvs, fmt.Sprintf("nc=%08x", inp.NonceCount))
-	rvs = append(rvs, fmt.Sprintf("qop=%s", "auth"))
+	rvs = append(rvs, fmt.Sprintf("qop=%s", qop))
 	rvs = append(rvs, fmt.Sprintf("response=%v", strconv.Quote(response)))
-	rvs = append(rvs, fmt.Sprintf("algorithm=%v", strconv.Quote(a.Algorithm)))
+	if inp.Quirks.UnquotedAlgorithm {
+		rvs = append(rvs, fmt.Sprintf("algorithm=%v", a.Algorithm))
+	} else {
+		rvs = append(rvs, fmt.Sprintf("algorithm=%v", strconv.Quote(a.Algorithm)))
+	}
 	if a.Opaque != "" {
 		rvs = append(rvs, fmt.Sprintf("opaque=%v", strconv.Quote(a.Opaque)))
 	}
+	if userhash {
+		rvs = append(rvs, "userhash=true")
+	}
+	names := make([]string, 0, len(inp.ExtraParams))
+	for name := range inp.ExtraParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rvs = append(rvs, fmt.Sprintf("%s=%v", name, strconv.Quote(inp.ExtraParams[name])))
+	}
+
+	return "Digest " + strings.Join(rvs, inp.Quirks.separator()), nil
+}
+
+// hasControl reports whether v has control characters, which can't be sent
+// in a quoted-string.
+func hasControl(v string) bool {
+	for _, r := range v {
+		if (r < 0x20 && r != '\t') || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeExtValue encodes v in the RFC 5987 ext-value notation, with the
+// UTF-8 charset.
+func encodeExtValue(v string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.WriteString("UTF-8''")
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xf])
+	}
+	return b.String()
+}
+
+// hash returns the hex encoded hash function of the algorithm.
+func (a *WWWAuth) hash() (func(format string, v ...interface{}) string, error) {
+	if alg, ok := lookupAlgorithm(a.Algorithm); ok {
+		return alg.hash, nil
+	}
+	return nil, fmt.Errorf("%w ('%s')", ErrUnsupportedAlgorithm, a.Algorithm)
+}
+
+// ha1 returns H(A1). For the session variants A1 also covers the nonce and
+// the cnonce (RFC 7616 section 3.4.2), so the same cnonce has to be used for
+// every request of the session.
+func (a *WWWAuth) ha1(hash func(format string, v ...interface{}) string, inp DigestInput) string {
+	ha1 := strings.ToLower(inp.HA1)
+	switch {
+	case ha1 != "":
+	case inp.Secret != nil:
+		alg, _ := lookupAlgorithm(a.Algorithm)
+		ha1 = inp.Secret.hashA1(alg.new(), inp.Username, a.Realm)
+	default:
+		ha1 = hash("%s:%s:%s", inp.Username, a.Realm, inp.Password)
+	}
+	if isSessionAlgorithm(a.Algorithm) {
+		return hash("%s:%s:%s", ha1, a.Nonce, inp.Cnonce)
+	}
+	return ha1
+}
+
+// Digest algorithms implemented by this package.
+const (
+	AlgorithmMD5        = "MD5"
+	AlgorithmMD5Sess    = "MD5-sess"
+	AlgorithmSHA256     = "SHA-256"
+	AlgorithmSHA256Sess = "SHA-256-sess"
+)
+
+// digestAlgorithm is a hash family. Each family also has a session variant,
+// named with the "-sess" suffix, that only differs in A1.
+type digestAlgorithm struct {
+	hash func(format string, v ...interface{}) string
+	new  func() hash.Hash
+	// strength ranks the families, the strongest having the highest value
+	strength int
+}
+
+// digestAlgorithms are the hash families implemented by this package, by
+// upper case name.
+var digestAlgorithms = map[string]digestAlgorithm{
+	"MD5":     {hash: md5hex, new: md5.New, strength: 1},
+	"SHA-256": {hash: sha256hex, new: sha256.New, strength: 2},
+}
+
+// lookupAlgorithm returns the hash family of algorithm, which may be a session
+// variant. An empty algorithm means MD5.
+func lookupAlgorithm(algorithm string) (digestAlgorithm, bool) {
+	if algorithm == "" {
+		algorithm = AlgorithmMD5
+	}
+	alg, ok := digestAlgorithms[strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")]
+	return alg, ok
+}
+
+// isSessionAlgorithm reports whether algorithm is a session variant, whose
+// A1 depends on the cnonce.
+func isSessionAlgorithm(algorithm string) bool {
+	return strings.HasSuffix(strings.ToLower(algorithm), "-sess")
+}
 
-	return "Digest " + strings.Join(rvs, ", "), nil
+// algorithmStrength ranks the algorithms implemented by this package, the
+// strongest having the highest value. Unsupported algorithms rank 0.
+func algorithmStrength(algorithm string) int {
+	alg, _ := lookupAlgorithm(algorithm)
+	return alg.strength
 }
 
-func (a *WWWAuth) ha1(inp DigestInput) (ha1 string, err error) {
-	switch a.Algorithm {
-	case "", "MD5":
-		return md5hex("%s:%s:%s", inp.Username, a.Realm, inp.Password), nil
-	case "MD5-sess":
-		return md5hex("%s:%s:%08x", md5hex("%s:%s:%s", inp.Username, a.Realm, inp.Password), a.Nonce, inp.NonceCount), nil
+// ParseAuthParams splits a challenge or credentials header value of the form
+// `scheme k=v, k="v"` into the scheme and its parameters. Quoted values are
+// unquoted and parameter names are lowercased, as they are case-insensitive.
+func ParseAuthParams(v string) (scheme string, params map[string]string) {
+	v = strings.TrimSpace(v)
+	i := strings.IndexByte(v, ' ')
+	if i < 0 {
+		return v, map[string]string{}
 	}
-	return "", fmt.Errorf("not implemented")
+	params = make(map[string]string)
+	for k, pv := range parseParams(v[i+1:]) {
+		params[strings.ToLower(k)] = pv
+	}
+	return v[:i], params
 }
 
 // Digest qop="auth",algorithm=MD5,realm="monero-rpc",nonce="enL+8AmWO9KIVm9fEKxwIQ==",stale=false
 func parseDigest(rawDigest string) map[string]string {
+	return parseParams(rawDigest[7:])
+}
+
+func parseParams(raw string) map[string]string {
 	var state int
 	var quote bool
 	var backq int
 	var key, val bytes.Buffer
 	keys := make(map[string]string)
-	for _, r := range rawDigest[7:] {
+	for _, r := range raw {
 		if state == 0 {
 			if r == '=' {
 				state = 1
@@ -1,6 +1,8 @@
 package httpdigest
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,3 +26,224 @@ func TestDigestAuthMD5(t *testing.T) {
 	expected := `Digest username="john", realm="monero-rpc", nonce="E/fIX+Kmic5GyK1ydhPoFA==", uri="/json_rpc", cnonce="MWI5ZjNlNTc3ZDBhNTUxMWU1NGZmYmI3YzE5YWQ4ODE=", nc=00000001, qop=auth, response="639f9031211b1b7b9cfbabe9e0a7fd44", algorithm="MD5"`
 	assert.Equal(t, expected, auth0)
 }
+
+func TestDigestQuirks(t *testing.T) {
+	d := `Digest qop="auth",algorithm=SHA-256,realm="monero-rpc",nonce="E/fIX+Kmic5GyK1ydhPoFA==",stale=false`
+	wwwa, err := ParseWWWAuthenticate(d)
+	assert.NoError(t, err)
+	auth0, err := wwwa.Digest(DigestInput{
+		DigestURI: "/json_rpc",
+		Cnonce:    "MWI5ZjNlNTc3ZDBhNTUxMWU1NGZmYmI3YzE5YWQ4ODE=",
+		Method:    "POST",
+		Username:  "john",
+		Password:  "doe",
+		Quirks: Quirks{
+			UnquotedAlgorithm: true,
+			Compact:           true,
+			ForceMD5:          true,
+		},
+	})
+	assert.NoError(t, err)
+	expected := `Digest username="john",realm="monero-rpc",nonce="E/fIX+Kmic5GyK1ydhPoFA==",uri="/json_rpc",cnonce="MWI5ZjNlNTc3ZDBhNTUxMWU1NGZmYmI3YzE5YWQ4ODE=",nc=00000001,qop=auth,response="639f9031211b1b7b9cfbabe9e0a7fd44",algorithm=MD5`
+	assert.Equal(t, expected, auth0)
+	assert.Equal(t, "SHA-256", wwwa.Algorithm)
+}
+
+func TestDigestRFC2617Vector(t *testing.T) {
+	wwwa, err := ParseWWWAuthenticate(`Digest realm="testrealm@host.com", qop="auth", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`)
+	assert.NoError(t, err)
+	auth, err := wwwa.Digest(DigestInput{
+		DigestURI: "/dir/index.html",
+		Cnonce:    "0a4f113b",
+		Method:    "GET",
+		Username:  "Mufasa",
+		Password:  "Circle Of Life",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "6629fae49393a05397450978507c4ef1", parseDigest(auth)["response"])
+}
+
+func TestDigestMD5Sess(t *testing.T) {
+	tests := []struct {
+		challenge string
+		inp       DigestInput
+		response  string
+	}{
+		{
+			challenge: `Digest qop="auth",algorithm=MD5-sess,realm="monero-rpc",nonce="E/fIX+Kmic5GyK1ydhPoFA=="`,
+			inp: DigestInput{
+				DigestURI: "/json_rpc",
+				Cnonce:    "MWI5ZjNlNTc3ZDBhNTUxMWU1NGZmYmI3YzE5YWQ4ODE=",
+				Method:    "POST",
+				Username:  "john",
+				Password:  "doe",
+			},
+			response: "1f0033662f920929a9be2f14b39d04fe",
+		},
+		{
+			challenge: `Digest realm="testrealm@host.com", qop="auth", algorithm="MD5-sess", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`,
+			inp: DigestInput{
+				DigestURI:  "/dir/index.html",
+				NonceCount: 2,
+				Cnonce:     "0a4f113b",
+				Method:     "GET",
+				Username:   "Mufasa",
+				Password:   "Circle Of Life",
+			},
+			response: "d16df0df0d92cef8935129145e21b5e1",
+		},
+	}
+	for _, tt := range tests {
+		wwwa, err := ParseWWWAuthenticate(tt.challenge)
+		assert.NoError(t, err)
+		auth, err := wwwa.Digest(tt.inp)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.response, parseDigest(auth)["response"])
+	}
+}
+
+func TestDigestRFC7616SHA256Vector(t *testing.T) {
+	wwwa, err := ParseWWWAuthenticate(`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`)
+	assert.NoError(t, err)
+	auth, err := wwwa.Digest(DigestInput{
+		DigestURI: "/dir/index.html",
+		Cnonce:    "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+		Method:    "GET",
+		Username:  "Mufasa",
+		Password:  "Circle of Life",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1", parseDigest(auth)["response"])
+}
+
+func TestParseAuthParams(t *testing.T) {
+	scheme, params := ParseAuthParams(`Bearer realm="api, v2", Error=invalid_token, error_description="The token \"x\" expired"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":             "api, v2",
+		"error":             "invalid_token",
+		"error_description": `The token "x" expired`,
+	}, params)
+
+	scheme, params = ParseAuthParams("  Negotiate ")
+	assert.Equal(t, "Negotiate", scheme)
+	assert.Empty(t, params)
+
+	w, err := ParseWWWAuthenticate(`Digest REALM="r", Nonce="n"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "r", w.Realm)
+	assert.Equal(t, "n", w.Nonce)
+
+	w, err = ParseWWWAuthenticate(`Basic realm="b", Digest realm="r", nonce="n", qop="auth", Bearer realm="api"`)
+	assert.NoError(t, err)
+	assert.Equal(t, &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth", Params: map[string]string{"realm": "r", "nonce": "n", "qop": "auth"}}, w)
+	_, err = ParseWWWAuthenticate(`Basic realm="b", Bearer realm="api"`)
+	assert.True(t, errors.Is(err, ErrBadChallenge))
+}
+
+func TestDigestAuthInt(t *testing.T) {
+	w := &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth, auth-int"}
+	inp := DigestInput{Username: "u", Password: "p", DigestURI: "/up", NonceCount: 1, Cnonce: "c", Method: "PUT", Body: []byte("data")}
+	auth, err := w.Digest(inp)
+	assert.NoError(t, err)
+	assert.Equal(t, "auth", parseDigest(auth)["qop"])
+
+	inp.Qop = "auth-int"
+	auth, err = w.Digest(inp)
+	assert.NoError(t, err)
+	p := parseDigest(auth)
+	assert.Equal(t, "auth-int", p["qop"])
+	ha1 := md5hex("u:r:p")
+	ha2 := md5hex("PUT:/up:%s", md5hex("data"))
+	assert.Equal(t, md5hex("%s:n:00000001:c:auth-int:%s", ha1, ha2), p["response"])
+}
+
+func TestDigestSHA256Sess(t *testing.T) {
+	w := &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth", Algorithm: AlgorithmSHA256Sess}
+	auth, err := w.Digest(DigestInput{Username: "u", Password: "p", DigestURI: "/", NonceCount: 1, Cnonce: "c", Method: "GET"})
+	assert.NoError(t, err)
+	p := parseDigest(auth)
+	ha1 := sha256hex("%s:n:c", sha256hex("u:r:p"))
+	ha2 := sha256hex("GET:/")
+	assert.Equal(t, sha256hex("%s:n:00000001:c:auth:%s", ha1, ha2), p["response"])
+	assert.Equal(t, AlgorithmSHA256Sess, p["algorithm"])
+
+	w.Algorithm = "-sess"
+	_, err = w.Digest(DigestInput{Username: "u", Password: "p", DigestURI: "/", Method: "GET"})
+	assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm))
+}
+
+func TestDigestUserhash(t *testing.T) {
+	wwwa, err := ParseWWWAuthenticate(`Digest realm="api@example.org", qop="auth", algorithm=SHA-256, nonce="n", userhash=true`)
+	assert.NoError(t, err)
+	auth, err := wwwa.Digest(DigestInput{Username: "Jäsøn Doe", Password: "Secret, or not?", DigestURI: "/doe.json", NonceCount: 1, Cnonce: "c", Method: "GET"})
+	assert.NoError(t, err)
+	p := parseDigest(auth)
+	assert.Equal(t, sha256hex("Jäsøn Doe:api@example.org"), p["username"])
+	assert.Equal(t, "true", p["userhash"])
+	ha1 := sha256hex("Jäsøn Doe:api@example.org:Secret, or not?")
+	assert.Equal(t, sha256hex("%s:n:00000001:c:auth:%s", ha1, sha256hex("GET:/doe.json")), p["response"])
+}
+
+func TestDigestExtendedUsername(t *testing.T) {
+	// RFC 7616 section 3.9.2
+	wwwa, err := ParseWWWAuthenticate(`Digest realm="api@example.org", qop="auth", algorithm=SHA-256, nonce="n", charset=UTF-8`)
+	assert.NoError(t, err)
+	assert.Equal(t, "UTF-8", wwwa.Charset)
+	auth, err := wwwa.Digest(DigestInput{Username: "Jäsøn Doe", Password: "Secret, or not?", DigestURI: "/doe.json", Cnonce: "c", Method: "GET"})
+	assert.NoError(t, err)
+	assert.Contains(t, auth, "username*=UTF-8''J%C3%A4s%C3%B8n%20Doe, ")
+	assert.NotContains(t, auth, "username=")
+
+	auth, err = wwwa.Digest(DigestInput{Username: "john", Password: "doe", DigestURI: "/", Cnonce: "c", Method: "GET"})
+	assert.NoError(t, err)
+	assert.Contains(t, auth, `username="john"`)
+
+	// without the charset directive, the server may not know username*
+	wwwa.Charset = ""
+	auth, err = wwwa.Digest(DigestInput{Username: "Jäsøn Doe", Password: "Secret, or not?", DigestURI: "/doe.json", Cnonce: "c", Method: "GET"})
+	assert.NoError(t, err)
+	assert.Contains(t, auth, `username="Jäsøn Doe"`)
+	assert.NotContains(t, auth, "username*=")
+	// but a control character can't be quoted at all
+	auth, err = wwwa.Digest(DigestInput{Username: "john\x01", Password: "doe", DigestURI: "/", Cnonce: "c", Method: "GET"})
+	assert.NoError(t, err)
+	assert.Contains(t, auth, "username*=UTF-8''john%01, ")
+}
+
+func TestDigestHA1(t *testing.T) {
+	w := &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth"}
+	inp := DigestInput{Username: "u", Password: "p", DigestURI: "/", NonceCount: 1, Cnonce: "c", Method: "GET"}
+	want, err := w.Digest(inp)
+	assert.NoError(t, err)
+	inp.Password = ""
+	inp.HA1 = strings.ToUpper(md5hex("u:r:p"))
+	auth, err := w.Digest(inp)
+	assert.NoError(t, err)
+	assert.Equal(t, want, auth)
+
+	w.Algorithm = AlgorithmSHA256
+	_, err = w.Digest(inp)
+	assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm))
+}
+
+func TestDigestExtraParams(t *testing.T) {
+	w, err := ParseWWWAuthenticate(`Digest realm="r", nonce="n", qop="auth", X-Session="42", channel=3`)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", w.Params["x-session"])
+	assert.Equal(t, "3", w.Params["channel"])
+
+	auth, err := w.Digest(DigestInput{
+		Username:    "u",
+		Password:    "p",
+		Method:      "GET",
+		DigestURI:   "/",
+		Cnonce:      "c",
+		ExtraParams: map[string]string{"session": w.Params["x-session"], "client": "nvr"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(auth, `, client="nvr", session="42"`), auth)
+	p := parseDigest(auth)
+	assert.Equal(t, "42", p["session"])
+	assert.Equal(t, "u", p["username"])
+}
@@ -0,0 +1,40 @@
+package httpdigest
+
+import (
+	"context"
+	"net/http"
+)
+
+// Credentials holds the username and password used to answer a challenge.
+type Credentials struct {
+	Username string
+	Password string
+	// HA1, if set, is used instead of Password for digest challenges. It is
+	// the hex encoded H(username:realm:password), as stored in htdigest
+	// files, so it only answers challenges of the realm and hash family it
+	// was computed for.
+	HA1 string
+	// Secret, if set, holds the password instead of Password, so that it can
+	// be wiped from memory.
+	Secret *Secret
+}
+
+// Do sends a single request with digest authentication, without the need to
+// keep a Transport around. It is meant for webhooks and short scripts.
+//
+// The request is sent with client (http.DefaultClient if nil), wrapping its
+// transport. ctx replaces the context of req.
+func Do(ctx context.Context, client *http.Client, req *http.Request, creds Credentials) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	t := New(creds.Username, creds.Password)
+	t.HA1 = creds.HA1
+	t.Secret = creds.Secret
+	if client.Transport != nil {
+		t.Transport = client.Transport
+	}
+	cl := *client
+	cl.Transport = t
+	return cl.Do(req.WithContext(ctx))
+}
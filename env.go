@@ -0,0 +1,135 @@
+package httpdigest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Environment variables read by NewFromEnv.
+const (
+	EnvUsername = "HTTPDIGEST_USERNAME"
+	EnvPassword = "HTTPDIGEST_PASSWORD"
+)
+
+// NewFromEnv creates a digest transport with the credentials of the
+// HTTPDIGEST_USERNAME and HTTPDIGEST_PASSWORD environment variables. It fails
+// with ErrNoCredentials if the username is not set.
+func NewFromEnv() (*Transport, error) {
+	username := os.Getenv(EnvUsername)
+	if username == "" {
+		return nil, fmt.Errorf("%w (%s is not set)", ErrNoCredentials, EnvUsername)
+	}
+	return New(username, os.Getenv(EnvPassword)), nil
+}
+
+// NewFromNetrc creates a digest transport with the login and password of
+// machine in the netrc file: the file named by the NETRC environment variable,
+// or .netrc in the home directory (_netrc on Windows). The default entry is
+// used if machine has none. It fails with ErrNoCredentials if neither exists.
+func NewFromNetrc(machine string) (*Transport, error) {
+	name, err := netrcPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	creds, ok, err := parseNetrc(f, machine)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w (no entry for %s in %s)", ErrNoCredentials, machine, name)
+	}
+	return New(creds.Username, creds.Password), nil
+}
+
+func netrcPath() (string, error) {
+	if name := os.Getenv("NETRC"); name != "" {
+		return name, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc"), nil
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// parseNetrc returns the credentials of machine, or of the default entry,
+// from a netrc file.
+func parseNetrc(r io.Reader, machine string) (creds Credentials, ok bool, err error) {
+	var tokens []string
+	inMacro := false
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		for _, tok := range strings.Fields(line) {
+			if tok == "macdef" {
+				inMacro = true
+				break
+			}
+			tokens = append(tokens, tok)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Credentials{}, false, err
+	}
+
+	var found, def, cur *Credentials
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			cur = nil
+			if i+1 < len(tokens) {
+				i++
+				if tokens[i] == machine && found == nil {
+					found = &Credentials{}
+					cur = found
+				}
+			}
+		case "default":
+			cur = nil
+			if def == nil {
+				def = &Credentials{}
+				cur = def
+			}
+		case "login", "password", "account":
+			if i+1 == len(tokens) {
+				break
+			}
+			key := tokens[i]
+			i++
+			if cur == nil {
+				break
+			}
+			switch key {
+			case "login":
+				cur.Username = tokens[i]
+			case "password":
+				cur.Password = tokens[i]
+			}
+		}
+	}
+	switch {
+	case found != nil:
+		return *found, true, nil
+	case def != nil:
+		return *def, true, nil
+	}
+	return Credentials{}, false, nil
+}
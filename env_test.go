@@ -0,0 +1,71 @@
+package httpdigest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFromEnv(t *testing.T) {
+	defer os.Unsetenv(EnvUsername)
+	defer os.Unsetenv(EnvPassword)
+	os.Unsetenv(EnvUsername)
+	_, err := NewFromEnv()
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+
+	os.Setenv(EnvUsername, "john")
+	os.Setenv(EnvPassword, "doe")
+	tr, err := NewFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "john", tr.Username)
+	assert.Equal(t, "doe", tr.Password)
+}
+
+const testNetrc = `machine other.local login jane password secret
+macdef init
+machine camera.local login fake password fake
+
+machine camera.local
+	login john
+	account x
+	password doe
+default login anonymous password guest
+`
+
+func TestParseNetrc(t *testing.T) {
+	creds, ok, err := parseNetrc(strings.NewReader(testNetrc), "camera.local")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Credentials{Username: "john", Password: "doe"}, creds)
+
+	creds, ok, _ = parseNetrc(strings.NewReader(testNetrc), "nvr.local")
+	assert.True(t, ok)
+	assert.Equal(t, Credentials{Username: "anonymous", Password: "guest"}, creds)
+
+	_, ok, _ = parseNetrc(strings.NewReader("machine a login b password c"), "nvr.local")
+	assert.False(t, ok)
+}
+
+func TestNewFromNetrc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpdigest")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "netrc")
+	assert.NoError(t, ioutil.WriteFile(name, []byte(testNetrc), 0600))
+	defer os.Setenv("NETRC", os.Getenv("NETRC"))
+	os.Setenv("NETRC", name)
+
+	tr, err := NewFromNetrc("other.local")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", tr.Username)
+	assert.Equal(t, "secret", tr.Password)
+
+	assert.NoError(t, ioutil.WriteFile(name, []byte("machine a login b"), 0600))
+	_, err = NewFromNetrc("camera.local")
+	assert.True(t, errors.Is(err, ErrNoCredentials))
+}
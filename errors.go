@@ -0,0 +1,64 @@
+package httpdigest
+
+import "errors"
+
+var (
+	// ErrBadChallenge is returned when the WWW-Authenticate header is not a
+	// digest challenge.
+	ErrBadChallenge = errors.New("bad challenge")
+	// ErrNoAcceptableScheme is returned when the server only offers
+	// authentication schemes the transport is not configured to answer.
+	ErrNoAcceptableScheme = errors.New("no acceptable authentication scheme")
+	// ErrUnsupportedQop is returned when the server doesn't offer a qop this
+	// package implements.
+	ErrUnsupportedQop = errors.New("digest not implemented")
+	// ErrUnsupportedAlgorithm is returned when the server requires an
+	// algorithm this package doesn't implement.
+	ErrUnsupportedAlgorithm = errors.New("algorithm not implemented")
+	// ErrWeakAlgorithm is returned when the server only offers algorithms
+	// weaker than Transport.MinAlgorithm.
+	ErrWeakAlgorithm = errors.New("algorithm weaker than allowed")
+	// ErrNoCredentials is returned by NewFromEnv and NewFromNetrc when no
+	// credentials are found.
+	ErrNoCredentials = errors.New("no credentials found")
+	// ErrBadCredentials is returned by Ping when the server rejects the
+	// credentials.
+	ErrBadCredentials = errors.New("bad credentials")
+	// ErrUnreachable is matched (with errors.Is) by the errors Ping returns
+	// when the server can't be reached. The network error is still available
+	// with errors.Unwrap.
+	ErrUnreachable = errors.New("server unreachable")
+	// ErrNoTransport is returned when the underlying transport of a Transport
+	// is nil.
+	ErrNoTransport = errors.New("underlying transport is nil")
+	// ErrHandshakeTimeout is returned when the handshake takes longer than
+	// Transport.HandshakeTimeout.
+	ErrHandshakeTimeout = errors.New("digest handshake timed out")
+	// ErrTooManyAttempts is returned when the server keeps asking for a new
+	// handshake after Transport.MaxAuthAttempts authenticated requests.
+	ErrTooManyAttempts = errors.New("too many authentication attempts")
+	// ErrBodyNotReplayable is returned when Transport.RequireGetBody is set
+	// and the body of a request can't be read again.
+	ErrBodyNotReplayable = errors.New("request body can't be replayed without GetBody")
+	// ErrNeedsHTTPTransport is matched (with errors.Is) by the error of a
+	// Transport configured with an option, such as WithTLSConfig, that needs
+	// an *http.Transport as the underlying transport while it is another kind
+	// of RoundTripper.
+	ErrNeedsHTTPTransport = errors.New("option needs an *http.Transport as the underlying transport")
+)
+
+type unreachableError struct {
+	err error
+}
+
+func (e *unreachableError) Error() string {
+	return ErrUnreachable.Error() + ": " + e.err.Error()
+}
+
+func (e *unreachableError) Is(target error) bool {
+	return target == ErrUnreachable
+}
+
+func (e *unreachableError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,199 @@
+package httpdigest
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// hostAllowed reports whether the transport may authenticate requests to u,
+// according to AllowedHosts, DeniedHosts and HostFilter.
+func (t *Transport) hostAllowed(u *url.URL) bool {
+	for _, pattern := range t.DeniedHosts {
+		if matchHost(pattern, u) {
+			return false
+		}
+	}
+	allowed := len(t.AllowedHosts) == 0
+	for _, pattern := range t.AllowedHosts {
+		if matchHost(pattern, u) {
+			allowed = true
+			break
+		}
+	}
+	if allowed && t.HostFilter != nil {
+		return t.HostFilter(u.Host)
+	}
+	return allowed
+}
+
+// matchHost reports whether the host of u matches pattern. A pattern is a
+// host name, optionally with a port ("device.local:8080"), and may start with
+// "*." to match any subdomain ("*.example.com" matches "a.example.com" and
+// "a.b.example.com", but not "example.com"). Patterns without a port match
+// any port.
+func matchHost(pattern string, u *url.URL) bool {
+	pattern = canonicalHost(pattern)
+	host := canonicalHost(u.Hostname())
+	if _, port := splitHostPattern(pattern); port != "" {
+		host = canonicalHost(u.Host)
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// splitHostPattern splits a host pattern into host and port, handling
+// bracketed IPv6 literals.
+func splitHostPattern(pattern string) (host, port string) {
+	i := strings.LastIndexByte(pattern, ':')
+	if i < 0 || strings.LastIndexByte(pattern, ']') > i {
+		return pattern, ""
+	}
+	if strings.HasPrefix(pattern, "[") || strings.Count(pattern, ":") == 1 {
+		return pattern[:i], pattern[i+1:]
+	}
+	// bare IPv6 literal
+	return pattern, ""
+}
+
+// canonicalHost normalizes a host, with or without a port, so that equivalent
+// spellings compare equal: names are lower cased and labels with non-ASCII
+// characters are converted to their punycode (IDNA "xn--") form, and IP
+// addresses are written in their canonical form (keeping the IPv6 zone as
+// is). Unicode normalization beyond case folding is not performed.
+func canonicalHost(hostport string) string {
+	host, port := splitHostPattern(hostport)
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		if ip, ok := canonicalIP(host[1 : len(host)-1]); ok {
+			host = "[" + ip + "]"
+		}
+	} else if ip, ok := canonicalIP(host); ok {
+		host = ip
+	} else {
+		labels := strings.Split(strings.ToLower(host), ".")
+		for i, label := range labels {
+			if !isASCII(label) {
+				labels[i] = "xn--" + punycode(label)
+			}
+		}
+		host = strings.Join(labels, ".")
+	}
+	if port != "" {
+		return host + ":" + port
+	}
+	return host
+}
+
+// canonicalIP returns the canonical form of an IP address, which may carry
+// an IPv6 zone ("fe80::1%eth0"). Zones are case sensitive and kept as is.
+func canonicalIP(s string) (string, bool) {
+	addr, zone := s, ""
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		addr, zone = s[:i], s[i:]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || (zone != "" && ip.To4() != nil) {
+		return "", false
+	}
+	return ip.String() + zone, true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// punycode parameters (RFC 3492 section 5)
+const (
+	pcBase        = 36
+	pcTMin        = 1
+	pcTMax        = 26
+	pcSkew        = 38
+	pcDamp        = 700
+	pcInitialBias = 72
+	pcInitialN    = 128
+)
+
+// punycode encodes a label with the Punycode algorithm of RFC 3492.
+func punycode(label string) string {
+	runes := []rune(label)
+	out := make([]byte, 0, len(label)+8)
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := rune(pcInitialN), 0, pcInitialBias
+	for h < len(runes) {
+		m := rune(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := pcBase; ; k += pcBase {
+				t := k - bias
+				if t < pcTMin {
+					t = pcTMin
+				} else if t > pcTMax {
+					t = pcTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(pcBase-t)))
+				q = (q - t) / (pcBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= pcDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((pcBase-pcTMin)*pcTMax)/2 {
+		delta /= pcBase - pcTMin
+		k += pcBase
+	}
+	return k + (pcBase-pcTMin+1)*delta/(delta+pcSkew)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,180 @@
+package httpdigest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{"device.local", "http://Device.Local:8080/", true},
+		{"device.local:8080", "http://device.local:8080/", true},
+		{"device.local:8080", "http://device.local:9090/", false},
+		{"*.example.com", "https://a.b.example.com/", true},
+		{"*.example.com", "https://example.com/", false},
+		{"*.example.com", "https://badexample.com/", false},
+		{"::1", "http://[::1]:18082/", true},
+		{"[::1]:18082", "http://[::1]:18082/", true},
+		{"[::1]:18082", "http://[::1]:18083/", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.match, matchHost(tt.pattern, u), tt.pattern+" "+tt.url)
+	}
+}
+
+func TestTransportAllowedHosts(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.AllowedHosts = []string{"*.trusted.local"}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	tr.AllowedHosts = append(tr.AllowedHosts, "127.0.0.1")
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tr.DeniedHosts = []string{ts.Listener.Addr().String()}
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestTransportHostFilter(t *testing.T) {
+	ts := newTestServer(t)
+	var hosts []string
+	trusted := false
+	tr := New("john", "doe")
+	tr.HostFilter = func(host string) bool {
+		hosts = append(hosts, host)
+		return trusted
+	}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	trusted = true
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{ts.Listener.Addr().String(), ts.Listener.Addr().String()}, hosts)
+
+	// denied hosts are not submitted to the filter
+	tr.DeniedHosts = []string{"127.0.0.1"}
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Len(t, hosts, 2)
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := map[string]string{
+		"EXAMPLE.com":       "example.com",
+		"Example.COM:8080":  "example.com:8080",
+		"münchen.de":        "xn--mnchen-3ya.de",
+		"MÜNCHEN.de:443":    "xn--mnchen-3ya.de:443",
+		"bücher.example":    "xn--bcher-kva.example",
+		"例え.テスト":            "xn--r8jz45g.xn--zckzah",
+		"xn--mnchen-3ya.de": "xn--mnchen-3ya.de",
+		"[::1]:18082":       "[::1]:18082",
+		"192.168.0.1":       "192.168.0.1",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, canonicalHost(in), in)
+	}
+}
+
+// TestPunycode checks the sample strings of RFC 3492 section 7.1.
+func TestPunycode(t *testing.T) {
+	tests := map[string]string{
+		// (A) Arabic (Egyptian)
+		"ليهمابتكلموشعربي؟": "egbpdaj6bu4bxfgehfvwxn",
+		// (B) Chinese (simplified)
+		"他们为什么不说中文": "ihqwcrb4cv8a8dqg056pqjye",
+		// (C) Chinese (traditional)
+		"他們爲什麽不說中文": "ihqwctvzc91f659drss3x8bo0yb",
+		// (D) Czech
+		"Pročprostěnemluvíčesky": "Proprostnemluvesky-uyb24dma41a",
+		// (E) Hebrew
+		"למההםפשוטלאמדבריםעברית": "4dbcagdahymbxekheh6e0a7fei0b",
+		// (F) Hindi (Devanagari)
+		"यहलोगहिन्दीक्योंनहींबोलसकतेहैं": "i1baa7eci9glrd9b2ae1bj0hfcgg6iyaf8o0a1dig0cd",
+		// (G) Japanese (kanji and hiragana)
+		"なぜみんな日本語を話してくれないのか": "n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa",
+		// (H) Korean (Hangul syllables)
+		"세계의모든사람들이한국어를이해한다면얼마나좋을까": "989aomsvi5e83db1d2a355cv1e0vak1dwrv93d5xbh15a0dt30a5jpsd879ccm6fea98c",
+		// (I) Russian (Cyrillic), the sample without its mixed-case annotation
+		"почемужеонинеговорятпорусски": "b1abfaaepdrnnbgefbadotcwatmq2g4l",
+		// (J) Spanish
+		"PorquénopuedensimplementehablarenEspañol": "PorqunopuedensimplementehablarenEspaol-fmd56a",
+		// (K) Vietnamese
+		"TạisaohọkhôngthểchỉnóitiếngViệt": "TisaohkhngthchnitingVit-kjcr8268qyxafd2f1b9g",
+		// (L) to (R) Japanese music artists, song titles and TV programs
+		"3年B組金八先生":                  "3B-ww4c5e180e575a65lsy2b",
+		"安室奈美恵-with-SUPER-MONKEYS":  "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n",
+		"Hello-Another-Way-それぞれの場所": "Hello-Another-Way--fc4qua05auwb3674vfr0b",
+		"ひとつ屋根の下2":                  "2-u9tlzr9756bt3uc0v",
+		"MajiでKoiする5秒前":             "MajiKoi5-783gue6qz075azm5e",
+		"パフィーdeルンバ":                 "de-jg4avhby1noc0d",
+		"そのスピードで":                   "d9juau41awczczp",
+		// (S) ASCII only
+		"-> $1.00 <-": "-> $1.00 <--",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, punycode(in), in)
+	}
+}
+
+func TestAuthJarIDN(t *testing.T) {
+	jar := NewMemoryAuthJar()
+	u, _ := url.Parse("http://MÜNCHEN.de/")
+	jar.SetCredentials(u, Credentials{Username: "john"})
+	u, _ = url.Parse("http://xn--mnchen-3ya.de/a")
+	creds, ok := jar.Credentials(u)
+	assert.True(t, ok)
+	assert.Equal(t, "john", creds.Username)
+}
+
+func TestTransportIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback not available:", err)
+	}
+	ts := httptest.NewUnstartedServer(newTestServer(t).Config.Handler)
+	ts.Listener.Close()
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+
+	tr := New("nobody", "")
+	tr.AllowedHosts = []string{"::1"}
+	tr.Jar = NewMemoryAuthJar()
+	u, _ := url.Parse(ts.URL)
+	assert.Equal(t, "::1", u.Hostname())
+	u.Host = strings.Replace(u.Host, "[::1]", "[0:0::1]", 1)
+	tr.Jar.SetCredentials(u, Credentials{Username: "john", Password: "doe"})
+
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/status?id=1"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
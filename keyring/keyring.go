@@ -0,0 +1,117 @@
+// Package keyring provides an httpdigest.CredentialProvider backed by the
+// keyring of the operating system: the macOS Keychain, the Windows Credential
+// Manager or the Secret Service (GNOME Keyring, KWallet) on other Unix
+// systems, so desktop tools don't have to keep passwords in configuration
+// files.
+//
+// Each secret is looked up by service and account, the account being
+// "realm@host" and then "host". On macOS and with the Secret Service, the
+// secret is "username:password"; on Windows it is a generic credential named
+// "service:account", holding the username and the password. For example:
+//
+//	security add-generic-password -s httpdigest -a camera.local -w 'admin:12345'
+//	secret-tool store --label=camera service httpdigest account camera.local
+//	cmdkey /generic:httpdigest:camera.local /user:admin /pass:12345
+package keyring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gabstv/httpdigest"
+)
+
+// DefaultService is the service the secrets are looked up under when
+// Provider.Service is empty.
+const DefaultService = "httpdigest"
+
+// ErrNotFound is returned by a Store that has no secret for an account.
+var ErrNotFound = errors.New("secret not found")
+
+// Store reads secrets from a keyring.
+type Store interface {
+	// Get returns the secret of account under service, or an error
+	// matching ErrNotFound.
+	Get(ctx context.Context, service, account string) (string, error)
+}
+
+// SystemStore is the keyring of the operating system.
+var SystemStore Store = systemStore{}
+
+// Provider implements httpdigest.CredentialProvider with a keyring. Reading
+// the system keyring runs a command, so the credentials of each host and
+// realm are cached until the server rejects them (Provider also implements
+// httpdigest.CredentialInvalidator). A Provider must not be copied after
+// first use.
+type Provider struct {
+	// Service names the secrets, DefaultService if empty.
+	Service string
+	// Store is the keyring, SystemStore if nil.
+	Store Store
+
+	mu    sync.Mutex
+	cache map[string]httpdigest.Credentials
+}
+
+// Credentials implements httpdigest.CredentialProvider. Hosts without a
+// secret get empty credentials, so the transport falls back to its own.
+func (p *Provider) Credentials(ctx context.Context, host, realm string) (httpdigest.Credentials, error) {
+	key := p.cacheKey(host, realm)
+	p.mu.Lock()
+	creds, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		return creds, nil
+	}
+	creds, err := p.lookup(ctx, host, realm)
+	if err != nil {
+		return creds, err
+	}
+	p.mu.Lock()
+	if p.cache == nil {
+		p.cache = make(map[string]httpdigest.Credentials)
+	}
+	p.cache[key] = creds
+	p.mu.Unlock()
+	return creds, nil
+}
+
+// Invalidate implements httpdigest.CredentialInvalidator: the secret of the
+// realm of host is read again from the keyring by the next request.
+func (p *Provider) Invalidate(ctx context.Context, host, realm string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.cache, p.cacheKey(host, realm))
+}
+
+func (p *Provider) cacheKey(host, realm string) string {
+	return p.Service + "/" + realm + "@" + host
+}
+
+func (p *Provider) lookup(ctx context.Context, host, realm string) (httpdigest.Credentials, error) {
+	service, store := p.Service, p.Store
+	if service == "" {
+		service = DefaultService
+	}
+	if store == nil {
+		store = SystemStore
+	}
+	for _, account := range []string{realm + "@" + host, host} {
+		secret, err := store.Get(ctx, service, account)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return httpdigest.Credentials{}, err
+		}
+		i := strings.IndexByte(secret, ':')
+		if i < 0 {
+			return httpdigest.Credentials{}, fmt.Errorf("secret of %s is not username:password", account)
+		}
+		return httpdigest.Credentials{Username: secret[:i], Password: secret[i+1:]}, nil
+	}
+	return httpdigest.Credentials{}, nil
+}
@@ -0,0 +1,75 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gabstv/httpdigest"
+	"github.com/stretchr/testify/assert"
+)
+
+type mapStore map[string]string
+
+func (m mapStore) Get(ctx context.Context, service, account string) (string, error) {
+	if secret, ok := m[service+"/"+account]; ok {
+		return secret, nil
+	}
+	return "", ErrNotFound
+}
+
+func TestProvider(t *testing.T) {
+	p := &Provider{Store: mapStore{
+		"httpdigest/camera.local":       "viewer:1234",
+		"httpdigest/admin@camera.local": "admin:a:b",
+		"other/camera.local":            "other:x",
+		"httpdigest/broken.local":       "nocolon",
+	}}
+	get := func(host, realm string) httpdigest.Credentials {
+		creds, err := p.Credentials(context.Background(), host, realm)
+		assert.NoError(t, err)
+		return creds
+	}
+	assert.Equal(t, httpdigest.Credentials{Username: "admin", Password: "a:b"}, get("camera.local", "admin"))
+	assert.Equal(t, httpdigest.Credentials{Username: "viewer", Password: "1234"}, get("camera.local", "live"))
+	assert.Equal(t, httpdigest.Credentials{}, get("nvr.local", "admin"))
+
+	p.Service = "other"
+	assert.Equal(t, "other", get("camera.local", "admin").Username)
+
+	p.Service = ""
+	_, err := p.Credentials(context.Background(), "broken.local", "r")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
+
+type countingStore struct {
+	mapStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, service, account string) (string, error) {
+	s.gets++
+	return s.mapStore.Get(ctx, service, account)
+}
+
+func TestProviderCache(t *testing.T) {
+	store := &countingStore{mapStore: mapStore{"httpdigest/camera.local": "viewer:1234"}}
+	p := &Provider{Store: store}
+	var _ httpdigest.CredentialInvalidator = p
+	for i := 0; i < 3; i++ {
+		creds, err := p.Credentials(context.Background(), "camera.local", "live")
+		assert.NoError(t, err)
+		assert.Equal(t, "viewer", creds.Username)
+	}
+	// realm@host, then host
+	assert.Equal(t, 2, store.gets)
+
+	// the rotated password is read once the old one is rejected
+	store.mapStore["httpdigest/camera.local"] = "viewer:5678"
+	p.Invalidate(context.Background(), "camera.local", "live")
+	creds, err := p.Credentials(context.Background(), "camera.local", "live")
+	assert.NoError(t, err)
+	assert.Equal(t, "5678", creds.Password)
+	assert.Equal(t, 4, store.gets)
+}
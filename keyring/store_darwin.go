@@ -0,0 +1,24 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+type systemStore struct{}
+
+// Get reads a generic password of the login keychain with the security tool.
+func (systemStore) Get(ctx context.Context, service, account string) (string, error) {
+	out, err := exec.CommandContext(ctx, "security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 44 {
+		// errSecItemNotFound
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
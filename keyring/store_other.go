@@ -0,0 +1,16 @@
+//go:build !darwin && !windows && !linux && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !darwin,!windows,!linux,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package keyring
+
+import (
+	"context"
+	"errors"
+)
+
+type systemStore struct{}
+
+// Get fails, there is no supported keyring on this system.
+func (systemStore) Get(ctx context.Context, service, account string) (string, error) {
+	return "", errors.New("keyring: no system keyring on this platform")
+}
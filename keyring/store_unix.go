@@ -0,0 +1,28 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+// +build linux freebsd openbsd netbsd dragonfly
+
+package keyring
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+type systemStore struct{}
+
+// Get reads a secret of the Secret Service with the secret-tool of libsecret.
+func (systemStore) Get(ctx context.Context, service, account string) (string, error) {
+	out, err := exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "account", account).Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 1 && len(exit.Stderr) == 0 {
+		// secret-tool exits with 1 and prints nothing when nothing matches;
+		// it prints the reason of any other failure, such as a locked
+		// collection or no Secret Service running, to stderr
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,68 @@
+package httpdigest
+
+import (
+	"net/url"
+	"sync"
+)
+
+// maxNoncesPerHost bounds the number of nonces whose count is remembered for
+// each server.
+const maxNoncesPerHost = 16
+
+// nonceCounter hands out the nonce-count (nc) values, which must increase
+// with every request sent with the same nonce. Counts are kept per server for
+// its most recent nonces. The zero value is ready to use.
+type nonceCounter struct {
+	mu sync.Mutex
+	m  map[string][]nonceCount
+}
+
+type nonceCount struct {
+	nonce string
+	nc    uint
+	// cnonce is the client nonce of the session started with nonce, for the
+	// session algorithm variants.
+	cnonce string
+}
+
+// next returns the count for the next request to u with nonce.
+func (c *nonceCounter) next(u *url.URL, nonce string) uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entry(u, nonce)
+	e.nc++
+	return e.nc
+}
+
+// session returns the cnonce of the session started with nonce on the
+// server of u. If there is none yet, cnonce starts the session.
+func (c *nonceCounter) session(u *url.URL, nonce, cnonce string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entry(u, nonce)
+	if e.cnonce == "" {
+		e.cnonce = cnonce
+	}
+	return e.cnonce
+}
+
+// entry returns the entry of nonce, creating it if needed. c.mu must be
+// held.
+func (c *nonceCounter) entry(u *url.URL, nonce string) *nonceCount {
+	if c.m == nil {
+		c.m = make(map[string][]nonceCount)
+	}
+	key := jarRoot(u)
+	counts := c.m[key]
+	for i := range counts {
+		if counts[i].nonce == nonce {
+			return &counts[i]
+		}
+	}
+	if len(counts) >= maxNoncesPerHost {
+		counts = append(counts[:0], counts[1:]...)
+	}
+	counts = append(counts, nonceCount{nonce: nonce})
+	c.m[key] = counts
+	return &counts[len(counts)-1]
+}
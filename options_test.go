@@ -0,0 +1,158 @@
+package httpdigest
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithInsecureSkipVerify(t *testing.T) {
+	ts := newTestServer(t)
+	tlsts := httptest.NewUnstartedServer(ts.Config.Handler)
+	tlsts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	tlsts.StartTLS()
+	defer tlsts.Close()
+
+	cl, err := NewClient("john", "doe")
+	assert.NoError(t, err)
+	_, err = cl.Get(tlsts.URL)
+	assert.Error(t, err)
+
+	cl, err = NewClient("john", "doe", WithInsecureSkipVerify())
+	assert.NoError(t, err)
+	resp, err := cl.Get(tlsts.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	if cfg := http.DefaultTransport.(*http.Transport).TLSClientConfig; cfg != nil {
+		assert.False(t, cfg.InsecureSkipVerify)
+	}
+}
+
+func TestTransportOptionsNeedHTTPTransport(t *testing.T) {
+	// a transport that does more than sending the request, such as another
+	// Transport, is neither replaced nor left unconfigured
+	inner := New("proxyuser", "secret")
+	for _, opt := range []Option{
+		WithInsecureSkipVerify(),
+		WithUnixSocket("/run/app.sock"),
+		WithProxy(&url.URL{Scheme: "http", Host: "proxy.local:3128"}),
+	} {
+		tr := New("john", "doe", WithTransport(inner), opt)
+		assert.Equal(t, http.RoundTripper(inner), tr.Transport)
+		_, err := tr.Client()
+		assert.True(t, errors.Is(err, ErrNeedsHTTPTransport))
+		_, err = tr.RoundTrip(mustRequest(t, http.MethodGet, "http://example.com/"))
+		assert.True(t, errors.Is(err, ErrNeedsHTTPTransport))
+		_, err = NewClient("john", "doe", WithTransport(inner), opt)
+		assert.True(t, errors.Is(err, ErrNeedsHTTPTransport))
+	}
+	_, err := NewWithProxy("john", "doe", "http://proxy.local:3128", WithTransport(inner), WithInsecureSkipVerify())
+	assert.True(t, errors.Is(err, ErrNeedsHTTPTransport))
+
+	base := &http.Transport{}
+	tr := New("john", "doe", WithTransport(base), WithInsecureSkipVerify())
+	assert.NotEqual(t, http.RoundTripper(base), tr.Transport)
+	assert.True(t, tr.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	assert.True(t, base.TLSClientConfig == nil || !base.TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestNewUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpdigest")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "api.sock")
+	l, err := net.Listen("unix", sock)
+	assert.NoError(t, err)
+	ts := newTestServer(t)
+	unixts := httptest.NewUnstartedServer(ts.Config.Handler)
+	unixts.Listener.Close()
+	unixts.Listener = l
+	unixts.Start()
+	defer unixts.Close()
+
+	tr := NewUnix(sock, "john", "doe")
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, "http://device.local/api?x=1"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	assert.Equal(t, "device.local", reqs[len(reqs)-1].Host)
+	assert.Equal(t, "/api?x=1", parseDigest(reqs[len(reqs)-1].Header.Get("Authorization"))["uri"])
+}
+
+func TestNewWithProxy(t *testing.T) {
+	ts := newTestServer(t)
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.URL.Host)
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	tr, err := NewWithProxy("john", "doe", proxy.URL)
+	assert.NoError(t, err)
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, "http://device.local/api"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"device.local", "device.local"}, hosts)
+
+	tr, err = NewWithProxy("john", "doe", "socks5://gateway.local:1080")
+	assert.NoError(t, err)
+	u, err := tr.Transport.(*http.Transport).Proxy(mustRequest(t, http.MethodGet, "http://device.local/"))
+	assert.NoError(t, err)
+	assert.Equal(t, "socks5://gateway.local:1080", u.String())
+
+	for _, bad := range []string{
+		"http://[::1",
+		"proxy.local:3128",
+		"ftp://proxy.local",
+		"socks4://gateway.local:1080",
+		"http://",
+		"http:///path",
+	} {
+		_, err = NewWithProxy("john", "doe", bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	ts := newTestServer(t)
+	rt := &http.Transport{}
+	defer rt.CloseIdleConnections()
+	logger := log.New(ioutil.Discard, "", 0)
+	tr := New("john", "doe",
+		WithTransport(rt),
+		WithCnonceGen(func() string { return "fixed" }),
+		WithLogger(logger),
+		WithCache(false),
+		WithAlgorithmPolicy(AlgorithmMD5, AlgorithmSHA256, AlgorithmMD5),
+	)
+	assert.Equal(t, rt, tr.Transport)
+	assert.Equal(t, logger, tr.Logger)
+	assert.Equal(t, AlgorithmMD5, tr.MinAlgorithm)
+	assert.Equal(t, []string{AlgorithmSHA256, AlgorithmMD5}, tr.AlgorithmPreference)
+	for i := 0; i < 2; i++ {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	// without the cache, every request is challenged
+	reqs := ts.Requests()
+	assert.Len(t, reqs, 4)
+	assert.Equal(t, "fixed", parseDigest(reqs[3].Header.Get("Authorization"))["cnonce"])
+
+	assert.Equal(t, "abc", NewHA1("john", "abc", WithTransport(rt)).HA1)
+	assert.Equal(t, rt, NewProxy("john", "doe", WithTransport(rt)).Transport)
+}
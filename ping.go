@@ -0,0 +1,62 @@
+package httpdigest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Ping performs the digest handshake against rawURL with a GET request and
+// reports whether the credentials are accepted, without reading the response.
+// It returns nil if the server accepted the credentials, ErrBadCredentials if
+// it rejected them, an error matching ErrUnreachable (errors.Is) if it can't
+// be reached, or the context error. Other errors of the handshake, such as
+// ErrUnsupportedAlgorithm, ErrWeakAlgorithm, ErrUnsupportedQop,
+// ErrNoAcceptableScheme, ErrBadChallenge, ErrNoTransport, ErrTooManyAttempts,
+// ErrHandshakeTimeout or an error of the CredentialProvider, are returned
+// unchanged.
+func (t *Transport) Ping(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if networkError(err) {
+			return &unreachableError{err: err}
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == t.challengeStatus() {
+		return ErrBadCredentials
+	}
+	return nil
+}
+
+// networkError reports whether err means the server couldn't be reached or
+// talked to: a dial, DNS, read or write error, a lost connection or a failed
+// TLS handshake.
+func networkError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// url.Error implements net.Error whatever it wraps
+		return networkError(urlErr.Err)
+	}
+	var (
+		netErr    net.Error
+		recordErr tls.RecordHeaderError
+		authErr   x509.UnknownAuthorityError
+		hostErr   x509.HostnameError
+		certErr   x509.CertificateInvalidError
+	)
+	return connectionLost(err) || errors.As(err, &netErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &authErr) || errors.As(err, &hostErr) || errors.As(err, &certErr)
+}
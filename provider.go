@@ -0,0 +1,130 @@
+package httpdigest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CredentialProvider supplies the credentials for a challenge, so they can
+// come from a vault, a database or a prompt instead of the fields of the
+// Transport. It is consulted for every challenge; implementations must be
+// safe for concurrent use.
+type CredentialProvider interface {
+	// Credentials returns the credentials for the realm of host (including
+	// the port, if any). Empty credentials and a nil error make the
+	// transport fall back to its Jar, Username and Password.
+	Credentials(ctx context.Context, host, realm string) (Credentials, error)
+}
+
+// CredentialInvalidator is implemented by providers that cache credentials.
+// When the credentials of a provider that implements it are rejected, the
+// transport invalidates them and retries the request once with the
+// credentials the provider returns next, which lets rotated passwords take
+// effect without failing a request.
+type CredentialInvalidator interface {
+	// Invalidate drops the cached credentials for the realm of host.
+	Invalidate(ctx context.Context, host, realm string)
+}
+
+// CredentialProviderFunc adapts a function to the CredentialProvider
+// interface.
+type CredentialProviderFunc func(ctx context.Context, host, realm string) (Credentials, error)
+
+// Credentials implements CredentialProvider.
+func (f CredentialProviderFunc) Credentials(ctx context.Context, host, realm string) (Credentials, error) {
+	return f(ctx, host, realm)
+}
+
+// challengeCredentials returns the credentials answering a challenge for
+// realm, fallback being those of the URL, the jar or the transport.
+func (t *Transport) challengeCredentials(req *http.Request, realm string, fallback Credentials) (Credentials, error) {
+	if t.CredentialProvider == nil || t.usesURLCredentials(req.URL) {
+		return fallback, nil
+	}
+	creds, err := t.CredentialProvider.Credentials(req.Context(), t.spaceURL(req).Host, realm)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("credentials for realm '%s': %w", realm, err)
+	}
+	if creds == (Credentials{}) {
+		return fallback, nil
+	}
+	return creds, nil
+}
+
+// refreshedRequest returns a copy of req2, the authenticated request, signed
+// again after invalidating the credentials of the provider, when resp, its
+// response, rejects them. It returns nil if there is nothing to retry.
+func (t *Transport) refreshedRequest(req, req2 *http.Request, resp *http.Response, creds Credentials, quirks Quirks) *http.Request {
+	inv, ok := t.CredentialProvider.(CredentialInvalidator)
+	if !ok || !t.isChallenge(resp) || t.usesURLCredentials(req.URL) {
+		return nil
+	}
+	challenges := t.challengeValues(resp.Header)
+	realm := ""
+	if wwwa := bestDigestChallenge(challenges, t.algorithmRank); wwwa != nil {
+		if strings.EqualFold(wwwa.Stale, "true") {
+			// the credentials were accepted, only the nonce expired
+			return nil
+		}
+		realm = wwwa.Realm
+	} else if len(challenges) > 0 {
+		_, params := ParseAuthParams(challenges[0])
+		realm = params["realm"]
+	}
+	inv.Invalidate(req.Context(), t.spaceURL(req).Host, realm)
+	authh, err := t.authorization(req, resp.Header, creds, quirks)
+	if err != nil {
+		return nil
+	}
+	req3, err := rewindBody(req2)
+	if err != nil {
+		return nil
+	}
+	req3.Header.Set(t.authorizationHeader(), authh)
+	return req3
+}
+
+// CredentialMap is a CredentialProvider holding credentials per host and
+// realm, for a client that talks to many devices. The zero value is ready to
+// use.
+type CredentialMap struct {
+	mu      sync.RWMutex
+	entries map[credentialKey]Credentials
+}
+
+type credentialKey struct {
+	host, realm string
+}
+
+// Set stores the credentials for realm on host. host may include the port,
+// an entry with the port taking precedence over one without; an empty realm
+// matches any realm of the host.
+func (m *CredentialMap) Set(host, realm string, creds Credentials) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[credentialKey]Credentials)
+	}
+	m.entries[credentialKey{canonicalHost(host), realm}] = creds
+}
+
+// Credentials implements CredentialProvider. Hosts without an entry get
+// empty credentials, so the transport falls back to its own.
+func (m *CredentialMap) Credentials(ctx context.Context, host, realm string) (Credentials, error) {
+	u := &url.URL{Host: host}
+	hostport, hostname := canonicalHost(u.Host), canonicalHost(u.Hostname())
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range []credentialKey{
+		{hostport, realm}, {hostname, realm}, {hostport, ""}, {hostname, ""},
+	} {
+		if creds, ok := m.entries[k]; ok {
+			return creds, nil
+		}
+	}
+	return Credentials{}, nil
+}
@@ -0,0 +1,134 @@
+package httpdigest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialProvider(t *testing.T) {
+	ts := newTestServer(t)
+	var asked []string
+	tr := &Transport{Transport: http.DefaultTransport}
+	tr.CredentialProvider = CredentialProviderFunc(func(ctx context.Context, host, realm string) (Credentials, error) {
+		asked = append(asked, host+" "+realm)
+		return Credentials{Username: "john", Password: "doe"}, nil
+	})
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{ts.Listener.Addr().String() + " " + ts.Realm}, asked)
+
+	// empty credentials fall back to the fields of the transport
+	tr = New("john", "doe")
+	tr.CredentialProvider = CredentialProviderFunc(func(ctx context.Context, host, realm string) (Credentials, error) {
+		return Credentials{}, nil
+	})
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	errVault := errors.New("vault sealed")
+	tr.CredentialProvider = CredentialProviderFunc(func(ctx context.Context, host, realm string) (Credentials, error) {
+		return Credentials{}, errVault
+	})
+	_, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.True(t, errors.Is(err, errVault))
+}
+
+func TestCredentialProviderURLCredentials(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("", "")
+	asked := 0
+	tr.CredentialProvider = CredentialProviderFunc(func(ctx context.Context, host, realm string) (Credentials, error) {
+		asked++
+		return Credentials{Username: "jane", Password: "roe"}, nil
+	})
+	cl, err := tr.Client()
+	assert.NoError(t, err)
+	// the credentials of the URL take precedence, like over the jar
+	resp, err := cl.Get(strings.Replace(ts.URL, "http://", "http://john:doe@", 1))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, asked)
+	reqs := ts.Requests()
+	assert.Equal(t, "john", parseDigest(reqs[len(reqs)-1].Header.Get("Authorization"))["username"])
+}
+
+func TestCredentialMap(t *testing.T) {
+	var m CredentialMap
+	m.Set("camera.local", "", Credentials{Username: "any"})
+	m.Set("camera.local", "admin", Credentials{Username: "admin"})
+	m.Set("Camera.Local:8080", "admin", Credentials{Username: "admin8080"})
+	get := func(host, realm string) string {
+		creds, err := m.Credentials(context.Background(), host, realm)
+		assert.NoError(t, err)
+		return creds.Username
+	}
+	assert.Equal(t, "admin8080", get("camera.local:8080", "admin"))
+	assert.Equal(t, "admin", get("camera.local:80", "admin"))
+	assert.Equal(t, "any", get("camera.local:8080", "viewer"))
+	assert.Equal(t, "", get("nvr.local", "admin"))
+
+	ts := newTestServer(t)
+	m.Set(ts.Listener.Addr().String(), ts.Realm, Credentials{Username: "john", Password: "doe"})
+	tr := New("", "")
+	tr.CredentialProvider = &m
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// rotatingProvider caches the password of a vault whose password rotates.
+type rotatingProvider struct {
+	vault       string
+	cached      string
+	invalidated int
+}
+
+func (p *rotatingProvider) Credentials(ctx context.Context, host, realm string) (Credentials, error) {
+	if p.cached == "" {
+		p.cached = p.vault
+	}
+	return Credentials{Username: "john", Password: p.cached}, nil
+}
+
+func (p *rotatingProvider) Invalidate(ctx context.Context, host, realm string) {
+	p.invalidated++
+	p.cached = ""
+}
+
+func TestCredentialInvalidator(t *testing.T) {
+	ts := newTestServer(t)
+	p := &rotatingProvider{vault: "old"}
+	tr := New("", "")
+	tr.CredentialProvider = p
+	// warm the cache with the old password
+	p.Credentials(context.Background(), "", "")
+	p.vault = "doe"
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, p.invalidated)
+	assert.Len(t, ts.Requests(), 3)
+
+	// a password that is still wrong is only retried once
+	p.vault = "wrong"
+	p.cached = ""
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 2, p.invalidated)
+	assert.Len(t, ts.Requests(), 6)
+}
@@ -0,0 +1,52 @@
+package httpdigest
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// NewProxy creates a transport that authenticates with an HTTP proxy, using
+// http.DefaultTransport, whose Proxy function selects the proxy.
+//
+// To authenticate with both the proxy and the server, chain two transports,
+// each keeping its own challenges:
+//
+//	t := New("user", "password")
+//	t.Transport = NewProxy("proxyuser", "proxypassword")
+//
+// Only plain HTTP requests can be authenticated this way: the credentials
+// of the CONNECT request that tunnels HTTPS are set with the
+// ProxyConnectHeader of http.Transport.
+func NewProxy(username, password string, opts ...Option) *Transport {
+	t := New(username, password)
+	t.Proxy = true
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// challengeStatus returns the status code of the challenges.
+func (t *Transport) challengeStatus() int {
+	if t.Proxy {
+		return http.StatusProxyAuthRequired
+	}
+	return http.StatusUnauthorized
+}
+
+// spaceURL returns the URL the challenges, nonce counts and handshakes of req
+// are kept under. It is the URL of req, except in Proxy mode: the proxy is the
+// protection space whatever the origin, so it is the URL of the proxy the
+// underlying transport selects for req, or a single URL for all the requests
+// if that is not known.
+func (t *Transport) spaceURL(req *http.Request) *url.URL {
+	if !t.Proxy {
+		return req.URL
+	}
+	if ht, ok := t.UnderlyingTransport().(*http.Transport); ok && ht.Proxy != nil {
+		if p, err := ht.Proxy(req); err == nil && p != nil {
+			return &url.URL{Scheme: p.Scheme, Host: p.Host, Path: "/"}
+		}
+	}
+	return &url.URL{Scheme: "proxy", Path: "/"}
+}
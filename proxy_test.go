@@ -0,0 +1,173 @@
+package httpdigest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyAndServer(t *testing.T) {
+	origin := newTestServer(t)
+	var challenged int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := false
+		if auth := r.Header.Get("Proxy-Authorization"); strings.HasPrefix(auth, "Digest ") {
+			p := parseDigest(auth)
+			ha1 := md5hex("%s:%s:%s", "proxyuser", "proxy", "secret")
+			ha2 := md5hex("%s:%s", r.Method, p["uri"])
+			ok = p["response"] == md5hex("%s:%s:%s:%s:auth:%s", ha1, "pn", p["nc"], p["cnonce"], ha2)
+		}
+		if !ok {
+			challenged++
+			w.Header().Set("Proxy-Authenticate", `Digest realm="proxy", nonce="pn", qop="auth"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		// act as the origin server, which never sees the proxy credentials
+		r.Header.Del("Proxy-Authorization")
+		origin.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	pt := NewProxy("proxyuser", "secret")
+	pt.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	tr := New("john", "doe")
+	tr.Transport = pt
+	for i := 0; i < 2; i++ {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, origin.URL+"/status"))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	// both challenges are answered once, then signed preemptively
+	assert.Equal(t, 1, challenged)
+	assert.Len(t, origin.Requests(), 3)
+
+	pt = NewProxy("proxyuser", "wrong")
+	pt.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	assert.Equal(t, ErrBadCredentials, pt.Ping(context.Background(), origin.URL))
+}
+
+func TestProxyRedirects(t *testing.T) {
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Proxy-Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			w.Header().Set("Proxy-Authenticate", `Digest realm="proxy", nonce="pn", qop="auth"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		hosts = append(hosts, r.Host)
+		switch r.URL.String() {
+		case "http://a.example/":
+			http.Redirect(w, r, "/same", http.StatusFound)
+		case "http://a.example/same":
+			http.Redirect(w, r, "http://b.example/other", http.StatusFound)
+		}
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	for _, policy := range []RedirectPolicy{RedirectResign, RedirectReuse, RedirectDrop} {
+		hosts = nil
+		pt := NewProxy("proxyuser", "secret")
+		pt.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+		pt.RedirectPolicy = policy
+		client := &http.Client{Transport: pt}
+		resp, err := client.Get("http://a.example/")
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			// the proxy credentials are sent after same-host and cross-host
+			// redirects alike
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+		assert.Equal(t, []string{"a.example", "a.example", "b.example"}, hosts)
+	}
+}
+
+func TestProxyNextNonce(t *testing.T) {
+	var originNonces, proxyNonces []string
+	var originChallenges, proxyChallenges int
+	originNonce, proxyNonce := "o0", "p0"
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Proxy-Authorization"); auth == "" || parseDigest(auth)["nonce"] != proxyNonce {
+			proxyChallenges++
+			w.Header().Set("Proxy-Authenticate", fmt.Sprintf(`Digest realm="proxy", nonce=%q, qop="auth"`, proxyNonce))
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		proxyNonces = append(proxyNonces, proxyNonce)
+		proxyNonce = fmt.Sprint("p", len(proxyNonces))
+		w.Header().Set("Proxy-Authentication-Info", fmt.Sprintf(`qop=auth, nextnonce=%q`, proxyNonce))
+		// act as the origin server, which hands out its own nextnonce
+		if auth := r.Header.Get("Authorization"); auth == "" || parseDigest(auth)["nonce"] != originNonce {
+			originChallenges++
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="origin", nonce=%q, qop="auth"`, originNonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		originNonces = append(originNonces, originNonce)
+		originNonce = fmt.Sprint("o", len(originNonces))
+		w.Header().Set("Authentication-Info", fmt.Sprintf(`qop=auth, nextnonce=%q`, originNonce))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	pt := NewProxy("proxyuser", "secret")
+	pt.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	tr := New("john", "doe")
+	tr.Transport = pt
+	for i := 0; i < 3; i++ {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, "http://origin.example/"))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+	// each transport follows the nextnonce of its own server
+	assert.Equal(t, []string{"o0", "o1", "o2"}, originNonces)
+	assert.Equal(t, []string{"p0", "p1", "p2", "p3"}, proxyNonces)
+	assert.Equal(t, 1, originChallenges)
+	assert.Equal(t, 1, proxyChallenges)
+}
+
+func TestProxyTwoOrigins(t *testing.T) {
+	var ncs []string
+	challenges := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Proxy-Authorization")
+		if auth != "" {
+			// strict nonce counts: each request must continue the count
+			nc := parseDigest(auth)["nc"]
+			if nc == fmt.Sprintf("%08x", len(ncs)+1) {
+				ncs = append(ncs, nc)
+				return
+			}
+		}
+		challenges++
+		w.Header().Set("Proxy-Authenticate", `Digest realm="proxy", nonce="p0", qop="auth"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	tr := NewProxy("proxyuser", "secret")
+	tr.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	for _, target := range []string{"http://a.example/", "http://b.example/", "http://a.example/"} {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, target))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+	// the proxy is one protection space, whatever the origin
+	assert.Equal(t, []string{"00000001", "00000002", "00000003"}, ncs)
+	assert.Equal(t, 1, challenges)
+}
@@ -0,0 +1,78 @@
+package httpdigest
+
+import (
+	"context"
+	"net/url"
+)
+
+// Quirks describes the deviations from RFC 2617 that some servers require in
+// order to accept a digest response. The zero value keeps the default
+// behavior of this package, so only the workarounds that are actually needed
+// have to be set.
+type Quirks struct {
+	// UnquotedAlgorithm sends the algorithm directive as a bare token
+	// (algorithm=MD5) instead of a quoted string (algorithm="MD5").
+	UnquotedAlgorithm bool
+	// OmitQuery leaves the query string out of the digest uri. Some servers
+	// only hash the path when validating the response.
+	OmitQuery bool
+	// Compact separates the directives of the Authorization header with ","
+	// instead of ", ".
+	Compact bool
+	// ProbeMethod, when not empty, is the method used for the unauthenticated
+	// request that fetches the challenge. The probe is sent without a body,
+	// and the original request is only sent once, with the credentials.
+	ProbeMethod string
+	// ForceMD5 ignores the algorithm advertised by the server and always
+	// answers with MD5.
+	ForceMD5 bool
+	// DefaultAlgorithm overrides Transport.DefaultAlgorithm, the algorithm
+	// assumed when the challenge doesn't specify one.
+	DefaultAlgorithm string
+}
+
+// quirksFor returns the quirks that apply to a request to u. An entry of
+// HostQuirks matching the host with the port takes precedence over one
+// matching the host alone, which takes precedence over the transport-wide
+// Quirks.
+func (t *Transport) quirksFor(u *url.URL) Quirks {
+	if len(t.HostQuirks) == 0 {
+		return t.Quirks
+	}
+	hostport, host := canonicalHost(u.Host), canonicalHost(u.Hostname())
+	q := t.Quirks
+	for k, hq := range t.HostQuirks {
+		switch canonicalHost(k) {
+		case hostport:
+			return hq
+		case host:
+			q = hq
+		}
+	}
+	return q
+}
+
+// digestURI returns the value of the uri directive for u.
+func (q Quirks) digestURI(u *url.URL) string {
+	if q.OmitQuery {
+		return (&url.URL{Path: u.Path, RawPath: u.RawPath, Opaque: u.Opaque}).RequestURI()
+	}
+	return u.RequestURI()
+}
+
+type digestURIKey struct{}
+
+// WithDigestURI returns a context that makes the Transport use uri as the
+// digest uri of the request, instead of the one derived from its URL. It is
+// meant for requests that go through a proxy rewriting the path before it
+// reaches the server checking the credentials.
+func WithDigestURI(ctx context.Context, uri string) context.Context {
+	return context.WithValue(ctx, digestURIKey{}, uri)
+}
+
+func (q Quirks) separator() string {
+	if q.Compact {
+		return ","
+	}
+	return ", "
+}
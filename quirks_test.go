@@ -0,0 +1,21 @@
+package httpdigest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDigestURI(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	req := mustRequest(t, http.MethodGet, ts.URL+"/proxied/path")
+	req = req.WithContext(WithDigestURI(req.Context(), "/original/path"))
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	reqs := ts.Requests()
+	p := parseDigest(reqs[len(reqs)-1].Header.Get("Authorization"))
+	assert.Equal(t, "/original/path", p["uri"])
+}
@@ -0,0 +1,57 @@
+package httpdigest
+
+import (
+	"net/http"
+)
+
+// RedirectPolicy controls how the transport authenticates a request that
+// follows a redirect to the same host.
+type RedirectPolicy int
+
+const (
+	// RedirectResign performs the handshake for the new URI, like for any
+	// other request. This is the default.
+	RedirectResign RedirectPolicy = iota
+	// RedirectReuse sends the Authorization header of the redirected request
+	// again, saving a handshake on servers that don't check the uri
+	// directive. The handshake is still performed if the server rejects it.
+	RedirectReuse
+	// RedirectDrop sends the request without credentials.
+	RedirectDrop
+)
+
+// sameHostRedirect reports whether req was created by http.Client to follow
+// a redirect that stays on the same host.
+func sameHostRedirect(req *http.Request) bool {
+	if req.Response == nil || req.Response.Request == nil {
+		return false
+	}
+	return canonicalHost(req.Response.Request.URL.Host) == canonicalHost(req.URL.Host)
+}
+
+// crossHostRedirect reports whether req was created by http.Client to follow
+// a redirect to another host.
+func crossHostRedirect(req *http.Request) bool {
+	return req.Response != nil && req.Response.Request != nil && !sameHostRedirect(req)
+}
+
+// withoutAuthorization returns req without credentials. http.Client already
+// drops the Authorization header on redirects to another host, but not a
+// custom AuthorizationHeader.
+func (t *Transport) withoutAuthorization(req *http.Request) *http.Request {
+	if req.Header.Get(t.authorizationHeader()) == "" {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.Header.Del(t.authorizationHeader())
+	return req
+}
+
+// redirectAuthorization returns the credentials sent on the request that was
+// redirected to req, if they should be reused.
+func (t *Transport) redirectAuthorization(req *http.Request) string {
+	if t.Proxy || t.RedirectPolicy != RedirectReuse || !sameHostRedirect(req) {
+		return ""
+	}
+	return req.Response.Request.Header.Get(t.authorizationHeader())
+}
@@ -0,0 +1,108 @@
+package httpdigest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HandshakeReport describes how a request was authenticated. It is meant to
+// be attached to bug reports, so it never contains the password nor the
+// digest response.
+type HandshakeReport struct {
+	// Challenges are the raw challenges of the server, if the request was
+	// challenged.
+	Challenges []string
+	// Preemptive is true when the request was signed before the server asked
+	// for authentication.
+	Preemptive bool
+	// Scheme is the authentication scheme of the credentials sent.
+	Scheme string
+	// Algorithm, Qop, URI, NonceCount and Cnonce are the directives of the
+	// digest credentials sent.
+	Algorithm  string
+	Qop        string
+	URI        string
+	NonceCount string
+	Cnonce     string
+	// Authorization is the credentials header sent, with the digest response
+	// (or the Basic credentials) redacted.
+	Authorization string
+	// StatusCode is the status of the last response received.
+	StatusCode int
+}
+
+type reportKey struct{}
+
+// WithHandshakeReport returns a context that makes the Transport describe the
+// handshake of the requests made with it in r.
+func WithHandshakeReport(ctx context.Context, r *HandshakeReport) context.Context {
+	return context.WithValue(ctx, reportKey{}, r)
+}
+
+// handshakeReport returns the report requested through the context, or nil.
+// The methods of HandshakeReport used by the transport accept a nil receiver.
+func handshakeReport(ctx context.Context) *HandshakeReport {
+	r, _ := ctx.Value(reportKey{}).(*HandshakeReport)
+	return r
+}
+
+var redactResponse = regexp.MustCompile(`(response=)("[^"]*"|[^,\s]*)`)
+
+// setAuthorization records the credentials sent.
+func (r *HandshakeReport) setAuthorization(auth string) {
+	if r == nil {
+		return
+	}
+	fields := strings.Fields(auth)
+	if len(fields) == 0 {
+		return
+	}
+	r.Scheme = fields[0]
+	if !isScheme(auth, "Digest") {
+		r.Authorization = r.Scheme + " [redacted]"
+		return
+	}
+	p := parseDigest(auth)
+	r.Algorithm = p["algorithm"]
+	r.Qop = p["qop"]
+	r.URI = p["uri"]
+	r.NonceCount = p["nc"]
+	r.Cnonce = p["cnonce"]
+	r.Authorization = redactResponse.ReplaceAllString(auth, `${1}"[redacted]"`)
+}
+
+func (r *HandshakeReport) setChallenges(challenges []string) {
+	if r != nil {
+		r.Challenges = challenges
+	}
+}
+
+func (r *HandshakeReport) setPreemptive(auth string) {
+	if r != nil {
+		r.Preemptive = true
+		r.setAuthorization(auth)
+	}
+}
+
+func (r *HandshakeReport) setResponse(resp *http.Response) {
+	if r != nil {
+		r.StatusCode = resp.StatusCode
+	}
+}
+
+// Explain sends req and returns the description of its handshake. The
+// response body is discarded.
+func (t *Transport) Explain(req *http.Request) (*HandshakeReport, error) {
+	r := &HandshakeReport{}
+	resp, err := t.RoundTrip(req.WithContext(WithHandshakeReport(req.Context(), r)))
+	if err != nil {
+		return r, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return r, nil
+}
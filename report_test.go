@@ -0,0 +1,36 @@
+package httpdigest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportExplain(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.CnonceGen = func() string { return "fixed" }
+	r, err := tr.Explain(mustRequest(t, http.MethodGet, ts.URL+"/status?x=1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Len(t, r.Challenges, 1)
+	assert.False(t, r.Preemptive)
+	assert.Equal(t, "Digest", r.Scheme)
+	assert.Equal(t, "MD5", r.Algorithm)
+	assert.Equal(t, "auth", r.Qop)
+	assert.Equal(t, "/status?x=1", r.URI)
+	assert.Equal(t, "00000001", r.NonceCount)
+	assert.Equal(t, "fixed", r.Cnonce)
+	assert.Contains(t, r.Authorization, `response="[redacted]"`)
+	assert.False(t, strings.Contains(r.Authorization, "doe"))
+}
+
+func TestHandshakeReportBasic(t *testing.T) {
+	r := &HandshakeReport{}
+	r.setAuthorization(basicAuth(Credentials{Username: "john", Password: "doe"}))
+	assert.Equal(t, "Basic [redacted]", r.Authorization)
+	var nilReport *HandshakeReport
+	assert.NotPanics(t, func() { nilReport.setAuthorization("Digest a=b") })
+}
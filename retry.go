@@ -0,0 +1,130 @@
+package httpdigest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultPreflightBackoff is the delay before the first retry of the first
+// attempt when Transport.PreflightBackoff is zero.
+const DefaultPreflightBackoff = 100 * time.Millisecond
+
+// DefaultMaxAuthAttempts is the number of authenticated requests sent for a
+// single request when Transport.MaxAuthAttempts is zero.
+const DefaultMaxAuthAttempts = 3
+
+func (t *Transport) maxAuthAttempts() int {
+	if t.MaxAuthAttempts > 0 {
+		return t.MaxAuthAttempts
+	}
+	return DefaultMaxAuthAttempts
+}
+
+// sendPreflight sends the first, unauthenticated attempt. Network errors and
+// 502, 503 and 504 responses are retried up to PreflightRetries times, with
+// the delay doubling after each attempt, as long as the method is idempotent
+// and the body can be sent again.
+func (t *Transport) sendPreflight(base http.RoundTripper, probe *http.Request) (*http.Response, error) {
+	backoff := t.PreflightBackoff
+	if backoff <= 0 {
+		backoff = DefaultPreflightBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		t.dumpRequest(probe)
+		resp, err := base.RoundTrip(probe)
+		if attempt >= t.PreflightRetries || !transientFailure(resp, err) || !isIdempotent(probe.Method) ||
+			probe.Context().Err() != nil {
+			return resp, err
+		}
+		if probe.Body != nil && probe.Body != http.NoBody {
+			if probe.GetBody == nil {
+				return resp, err
+			}
+			body, gerr := probe.GetBody()
+			if gerr != nil {
+				return resp, err
+			}
+			probe = probe.WithContext(probe.Context())
+			probe.Body = body
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(backoff << attempt)
+		select {
+		case <-probe.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("retrying the first attempt: %w", probe.Context().Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// transientFailure reports whether a round trip failed in a way that is
+// likely to go away on its own, as when a device is still booting.
+func transientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// canRetrySigned reports whether the authenticated request, which failed
+// with err, can be sent again.
+func (t *Transport) canRetrySigned(req *http.Request, err error) bool {
+	if t.DisableSignedRetry || req.Context().Err() != nil || !connectionLost(err) {
+		return false
+	}
+	if !isIdempotent(req.Method) && req.Header.Get("Idempotency-Key") == "" {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// connectionLost reports whether err means the connection was closed under
+// the request, as happens when a server drops a kept-alive connection: an
+// unexpected EOF, or a read or write error such as a reset.
+func connectionLost(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "read" || opErr.Op == "write") && !opErr.Timeout()
+}
+
+// rewindBody returns a copy of req, with its own header, whose body is read
+// again from GetBody.
+func rewindBody(req *http.Request) (*http.Request, error) {
+	r := req.WithContext(req.Context())
+	r.Header = req.Header.Clone()
+	if req.Body == nil || req.Body == http.NoBody {
+		return r, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("the request body can't be read again")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	return r, nil
+}
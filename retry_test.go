@@ -0,0 +1,103 @@
+package httpdigest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bootingServer fails the first requests the way a device that is still
+// booting does, then behaves as a testServer.
+func bootingServer(t *testing.T, failures int32, fail func(w http.ResponseWriter)) (*testServer, *int32) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	var n int32
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) <= failures {
+			fail(w)
+			return
+		}
+		base.ServeHTTP(w, r)
+	})
+	return ts, &n
+}
+
+func TestTransportPreflightRetries(t *testing.T) {
+	unavailable := func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }
+	dropped := func(w http.ResponseWriter) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+	for name, fail := range map[string]func(http.ResponseWriter){"503": unavailable, "dropped": dropped} {
+		t.Run(name, func(t *testing.T) {
+			ts, n := bootingServer(t, 2, fail)
+			tr := New("john", "doe")
+			tr.PreflightRetries = 2
+			tr.PreflightBackoff = time.Millisecond
+			resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, int32(4), atomic.LoadInt32(n))
+		})
+	}
+
+	ts, n := bootingServer(t, 2, unavailable)
+	tr := New("john", "doe")
+	tr.PreflightRetries = 2
+	tr.PreflightBackoff = time.Millisecond
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(n))
+}
+
+func TestTransportSignedRetry(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	var signed []string
+	var mu sync.Mutex
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			mu.Lock()
+			signed = append(signed, parseDigest(auth)["nc"])
+			first := len(signed) == 1
+			mu.Unlock()
+			if first {
+				ioutil.ReadAll(r.Body)
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+		}
+		base.ServeHTTP(w, r)
+	})
+
+	tr := New("john", "doe")
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/config", strings.NewReader("payload"))
+	resp, err := tr.RoundTrip(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	mu.Lock()
+	assert.Equal(t, []string{"00000001", "00000002"}, signed)
+	signed = nil
+	mu.Unlock()
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/config", strings.NewReader("payload"))
+	_, err = tr.RoundTrip(req)
+	assert.Error(t, err)
+	mu.Lock()
+	assert.Len(t, signed, 1)
+	mu.Unlock()
+}
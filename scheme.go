@@ -0,0 +1,193 @@
+package httpdigest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SchemeHandler answers the challenges of an authentication scheme other
+// than Digest, such as Bearer or a vendor token scheme.
+type SchemeHandler interface {
+	// Scheme returns the name of the scheme, as it appears at the start of
+	// the WWW-Authenticate header ("Bearer").
+	Scheme() string
+	// Authorize returns the value of the Authorization header that answers
+	// challenge (the whole WWW-Authenticate value) for req.
+	Authorize(req *http.Request, challenge string) (string, error)
+}
+
+// basicAuth returns the value of the Authorization header for Basic
+// authentication.
+func basicAuth(creds Credentials) string {
+	if creds.Secret != nil {
+		return creds.Secret.basicAuth(creds.Username)
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password))
+}
+
+// isScheme reports whether the challenge or credentials in v use the given
+// authentication scheme (compared case-insensitively). The scheme may stand
+// alone, as in the first challenge of Negotiate and NTLM.
+func isScheme(v, scheme string) bool {
+	v = strings.TrimSpace(v)
+	if len(v) < len(scheme) || !strings.EqualFold(v[:len(scheme)], scheme) {
+		return false
+	}
+	return len(v) == len(scheme) || v[len(scheme)] == ' ' || v[len(scheme)] == '\t'
+}
+
+// challengeValues returns the challenges of the challenge headers of h, one
+// per element, splitting the header values that hold several.
+func (t *Transport) challengeValues(h http.Header) []string {
+	return splitChallenges(h.Values(t.challengeHeader()))
+}
+
+// splitChallenges splits header values holding a comma separated list of
+// challenges (RFC 7235 section 4.1), such as `Basic realm="a", Digest
+// realm="a", nonce="b"`, into one challenge per element. A comma starts a new
+// challenge when it is followed by a token that isn't the name of a
+// parameter.
+func splitChallenges(values []string) []string {
+	var challenges []string
+	for _, v := range values {
+		start, quoted := 0, false
+		for i := 0; i < len(v); i++ {
+			switch c := v[i]; {
+			case c == '\\' && quoted:
+				i++
+			case c == '"':
+				quoted = !quoted
+			case c == ',' && !quoted && startsChallenge(v[i+1:]):
+				challenges = append(challenges, strings.TrimSpace(v[start:i]))
+				start = i + 1
+			}
+		}
+		if c := strings.TrimSpace(v[start:]); c != "" {
+			challenges = append(challenges, c)
+		}
+	}
+	return challenges
+}
+
+// startsChallenge reports whether s, the rest of a header value after a
+// comma, starts with an authentication scheme rather than a parameter.
+func startsChallenge(s string) bool {
+	s = strings.TrimLeft(s, " \t")
+	n := strings.IndexAny(s, " \t,=")
+	if n < 0 {
+		n = len(s)
+	}
+	if n == 0 {
+		return false
+	}
+	return !strings.HasPrefix(strings.TrimLeft(s[n:], " \t"), "=")
+}
+
+// findChallenge returns the first of the header values that uses the given
+// scheme, or an empty string.
+func findChallenge(values []string, scheme string) string {
+	for _, v := range values {
+		if isScheme(v, scheme) {
+			return v
+		}
+	}
+	return ""
+}
+
+// schemePreference returns the schemes the transport answers, most preferred
+// first.
+func (t *Transport) schemePreference() []string {
+	if len(t.SchemePreference) > 0 {
+		return t.SchemePreference
+	}
+	prefs := []string{"Digest", "Basic"}
+	for _, sh := range t.Schemes {
+		prefs = append(prefs, sh.Scheme())
+	}
+	return prefs
+}
+
+// schemeHandler returns the handler registered for scheme, or nil.
+func (t *Transport) schemeHandler(scheme string) SchemeHandler {
+	for _, sh := range t.Schemes {
+		if strings.EqualFold(sh.Scheme(), scheme) {
+			return sh
+		}
+	}
+	return nil
+}
+
+// bestDigestChallenge parses the digest challenges among the header values
+// and returns the one whose algorithm ranks highest. If no algorithm is
+// implemented, the first digest challenge is returned so that the error
+// reports what the server asked for.
+func bestDigestChallenge(values []string, rank func(algorithm string) int) *WWWAuth {
+	var first, best *WWWAuth
+	for _, v := range values {
+		if !isScheme(v, "Digest") {
+			continue
+		}
+		wwwa, err := ParseWWWAuthenticate(v)
+		if err != nil {
+			continue
+		}
+		if first == nil {
+			first = wwwa
+		}
+		if r := rank(wwwa.Algorithm); r > 0 && (best == nil || r > rank(best.Algorithm)) {
+			best = wwwa
+		}
+	}
+	if best == nil {
+		return first
+	}
+	return best
+}
+
+// algorithmRank ranks the algorithms the transport can answer, the preferred
+// one having the highest value: those of AlgorithmPreference in order, then
+// the others by strength. Unsupported algorithms, and those weaker than
+// MinAlgorithm, rank 0.
+func (t *Transport) algorithmRank(algorithm string) int {
+	s := algorithmStrength(algorithm)
+	if s == 0 || !t.strongEnough(algorithm) {
+		return 0
+	}
+	if algorithm == "" {
+		algorithm = AlgorithmMD5
+	}
+	for i, p := range t.AlgorithmPreference {
+		if strings.EqualFold(p, algorithm) {
+			return len(digestAlgorithms) + len(t.AlgorithmPreference) - i
+		}
+	}
+	return s
+}
+
+// strongEnough reports whether algorithm is at least as strong as
+// MinAlgorithm. No algorithm is, if MinAlgorithm is not one the package
+// implements.
+func (t *Transport) strongEnough(algorithm string) bool {
+	if t.MinAlgorithm == "" {
+		return true
+	}
+	min := algorithmStrength(t.MinAlgorithm)
+	return min > 0 && algorithmStrength(algorithm) >= min
+}
+
+// noAcceptableScheme returns the error for a response that only offers
+// schemes the transport won't answer.
+func noAcceptableScheme(values []string) error {
+	schemes := make([]string, 0, len(values))
+	for _, v := range values {
+		if f := strings.Fields(v); len(f) > 0 {
+			schemes = append(schemes, f[0])
+		}
+	}
+	if len(schemes) == 0 {
+		return fmt.Errorf("%w (no challenge)", ErrNoAcceptableScheme)
+	}
+	return fmt.Errorf("%w (offered: %s)", ErrNoAcceptableScheme, strings.Join(schemes, ", "))
+}
@@ -0,0 +1,196 @@
+package httpdigest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tokenScheme struct {
+	token string
+}
+
+func (s tokenScheme) Scheme() string { return "Bearer" }
+
+func (s tokenScheme) Authorize(req *http.Request, challenge string) (string, error) {
+	return "Bearer " + s.token, nil
+}
+
+func TestTransportSchemes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer secret" {
+			return
+		}
+		w.Header().Add("WWW-Authenticate", `Vendor realm="x"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="api"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	tr := New("john", "doe")
+	tr.Schemes = []SchemeHandler{tokenScheme{token: "secret"}}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestIsScheme(t *testing.T) {
+	assert.True(t, isScheme(`Digest realm="x"`, "Digest"))
+	assert.True(t, isScheme(` digest realm="x"`, "Digest"))
+	assert.False(t, isScheme(`DigestX realm="x"`, "Digest"))
+	assert.True(t, isScheme(`Negotiate`, "Negotiate"))
+	assert.True(t, isScheme("NTLM\tTlRMTVNTUAABAAAA", "NTLM"))
+	assert.False(t, isScheme(`Nego`, "Negotiate"))
+}
+
+type negotiateScheme struct{}
+
+func (negotiateScheme) Scheme() string { return "Negotiate" }
+
+func (negotiateScheme) Authorize(req *http.Request, challenge string) (string, error) {
+	return "Negotiate dG9rZW4=", nil
+}
+
+func TestTransportBareSchemeChallenge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Negotiate dG9rZW4=" {
+			return
+		}
+		// the first challenge of Negotiate has no token
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	tr := New("john", "doe")
+	tr.Schemes = []SchemeHandler{negotiateScheme{}}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSplitChallenges(t *testing.T) {
+	tests := map[string][]string{
+		`Digest realm="a", nonce="b"`: {`Digest realm="a", nonce="b"`},
+		`Basic realm="a", Digest realm="a", nonce="b", qop="auth"`: {
+			`Basic realm="a"`, `Digest realm="a", nonce="b", qop="auth"`,
+		},
+		`Newauth realm="apps", type=1, title="Login to \"apps\", Basic", Basic realm="simple"`: {
+			`Newauth realm="apps", type=1, title="Login to \"apps\", Basic"`, `Basic realm="simple"`,
+		},
+		`Negotiate abc==, Digest realm = "a", nonce="b"`: {`Negotiate abc==`, `Digest realm = "a", nonce="b"`},
+	}
+	for v, want := range tests {
+		assert.Equal(t, want, splitChallenges([]string{v}), v)
+	}
+	assert.Equal(t, []string{`Basic realm="a"`, `Digest realm="b"`}, splitChallenges([]string{`Basic realm="a"`, `Digest realm="b"`}))
+}
+
+func TestTransportCombinedChallenges(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, Digest realm=%q, nonce=%q, qop="auth"`, ts.Realm, ts.Realm, ts.Nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		base.ServeHTTP(w, r)
+	})
+	resp, err := New("john", "doe").RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSchemePreference(t *testing.T) {
+	h := http.Header{}
+	h.Add("WWW-Authenticate", `Bearer realm="api"`)
+	h.Add("WWW-Authenticate", `Basic realm="device"`)
+	h.Add("WWW-Authenticate", `Digest realm="device", nonce="abc", qop="auth", algorithm=SHA-512`)
+	h.Add("WWW-Authenticate", `Digest realm="device", nonce="def", qop="auth", algorithm=MD5`)
+	req := mustRequest(t, http.MethodGet, "https://device.local/")
+
+	tr := New("john", "doe")
+	tr.Schemes = []SchemeHandler{tokenScheme{token: "secret"}}
+	auth, err := tr.authorization(req, h, tr.credentials(req.URL), Quirks{})
+	assert.NoError(t, err)
+	assert.Contains(t, auth, `nonce="def"`)
+
+	tr.AllowBasic = true
+	tr.SchemePreference = []string{"Basic", "Digest"}
+	auth, err = tr.authorization(req, h, tr.credentials(req.URL), Quirks{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Basic am9objpkb2U=", auth)
+
+	tr.SchemePreference = []string{"bearer"}
+	auth, err = tr.authorization(req, h, tr.credentials(req.URL), Quirks{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer secret", auth)
+
+	tr.SchemePreference = []string{"Negotiate"}
+	_, err = tr.authorization(req, h, tr.credentials(req.URL), Quirks{})
+	assert.True(t, errors.Is(err, ErrNoAcceptableScheme))
+	assert.EqualError(t, err, "no acceptable authentication scheme (offered: Bearer, Basic, Digest, Digest)")
+}
+
+func TestAlgorithmPreference(t *testing.T) {
+	h := http.Header{}
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n1", qop="auth", algorithm=MD5`)
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n2", qop="auth", algorithm=SHA-256`)
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n3", qop="auth", algorithm=SHA-512-256`)
+	req := mustRequest(t, http.MethodGet, "http://example.com/")
+	algorithm := func(tr *Transport) string {
+		auth, err := tr.authorization(req, h, Credentials{Username: "john", Password: "doe"}, Quirks{})
+		assert.NoError(t, err)
+		return parseDigest(auth)["algorithm"]
+	}
+	tr := New("john", "doe")
+	assert.Equal(t, AlgorithmSHA256, algorithm(tr))
+	tr.AlgorithmPreference = []string{"SHA-512-256", "md5"}
+	assert.Equal(t, AlgorithmMD5, algorithm(tr))
+	tr.AlgorithmPreference = []string{AlgorithmMD5Sess}
+	assert.Equal(t, AlgorithmSHA256, algorithm(tr))
+}
+
+func TestMinAlgorithm(t *testing.T) {
+	h := http.Header{}
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n1", qop="auth", algorithm=MD5`)
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n2", qop="auth", algorithm=SHA-256`)
+	req := mustRequest(t, http.MethodGet, "http://example.com/")
+	creds := Credentials{Username: "john", Password: "doe"}
+	tr := New("john", "doe")
+	tr.MinAlgorithm = AlgorithmSHA256
+	tr.AlgorithmPreference = []string{AlgorithmMD5}
+	auth, err := tr.authorization(req, h, creds, Quirks{})
+	assert.NoError(t, err)
+	assert.Equal(t, AlgorithmSHA256, parseDigest(auth)["algorithm"])
+
+	_, err = tr.authorization(req, h, creds, Quirks{ForceMD5: true})
+	assert.True(t, errors.Is(err, ErrWeakAlgorithm))
+
+	h.Del("WWW-Authenticate")
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n1", qop="auth"`)
+	_, err = tr.authorization(req, h, creds, Quirks{})
+	assert.True(t, errors.Is(err, ErrWeakAlgorithm))
+}
+
+func TestMinAlgorithmUnknown(t *testing.T) {
+	h := http.Header{}
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n1", qop="auth", algorithm=MD5`)
+	h.Add("WWW-Authenticate", `Digest realm="r", nonce="n2", qop="auth", algorithm=SHA-256`)
+	req := mustRequest(t, http.MethodGet, "http://example.com/")
+	creds := Credentials{Username: "john", Password: "doe"}
+	for _, min := range []string{"SHA256", "SHA-512-256"} {
+		tr := New("john", "doe", WithAlgorithmPolicy(min))
+		// a misspelled minimum rejects every challenge rather than none
+		_, err := tr.authorization(req, h, creds, Quirks{})
+		assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm), err)
+	}
+}
@@ -0,0 +1,67 @@
+package httpdigest
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"sync"
+)
+
+// Secret holds a password in a byte slice that Destroy overwrites, for
+// programs that must not leave credentials in memory once they are done with
+// them. The digest is computed from the bytes directly, without copying the
+// password into strings. Basic credentials can't avoid a copy, as the header
+// itself is a string.
+type Secret struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+// NewSecret returns a Secret holding password. The slice is used as is, not
+// copied, so that Destroy wipes it; the caller must not modify it afterwards.
+func NewSecret(password []byte) *Secret {
+	return &Secret{b: password}
+}
+
+// Destroy overwrites the password with zeros. The Secret holds an empty
+// password afterwards.
+func (s *Secret) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.b {
+		s.b[i] = 0
+	}
+	s.b = nil
+}
+
+// String implements fmt.Stringer without revealing the password.
+func (s *Secret) String() string {
+	return "[redacted]"
+}
+
+// GoString implements fmt.GoStringer so that %#v is masked as well.
+func (s *Secret) GoString() string {
+	return "httpdigest.Secret{[redacted]}"
+}
+
+// hashA1 returns the hex encoded H(username:realm:password).
+func (s *Secret) hashA1(h hash.Hash, username, realm string) string {
+	h.Write([]byte(username + ":" + realm + ":"))
+	s.mu.Lock()
+	h.Write(s.b)
+	s.mu.Unlock()
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// basicAuth returns Basic credentials for username and the password.
+func (s *Secret) basicAuth(username string) string {
+	s.mu.Lock()
+	userpass := make([]byte, 0, len(username)+1+len(s.b))
+	userpass = append(append(append(userpass, username...), ':'), s.b...)
+	s.mu.Unlock()
+	auth := "Basic " + base64.StdEncoding.EncodeToString(userpass)
+	for i := range userpass {
+		userpass[i] = 0
+	}
+	return auth
+}
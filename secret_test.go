@@ -0,0 +1,42 @@
+package httpdigest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret(t *testing.T) {
+	for _, alg := range []string{AlgorithmMD5, AlgorithmSHA256Sess} {
+		w := &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth", Algorithm: alg}
+		inp := DigestInput{Username: "u", Password: "p", DigestURI: "/", NonceCount: 1, Cnonce: "c", Method: "GET"}
+		want, err := w.Digest(inp)
+		assert.NoError(t, err)
+		inp.Password = ""
+		inp.Secret = NewSecret([]byte("p"))
+		auth, err := w.Digest(inp)
+		assert.NoError(t, err)
+		assert.Equal(t, want, auth)
+	}
+	assert.Equal(t, basicAuth(Credentials{Username: "u", Password: "p"}), basicAuth(Credentials{Username: "u", Secret: NewSecret([]byte("p"))}))
+
+	password := []byte("doe")
+	s := NewSecret(password)
+	ts := newTestServer(t)
+	tr := New("john", "")
+	tr.Secret = s
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, fmt.Sprintf("%v %#v %v", s, s, tr), "doe")
+
+	s.Destroy()
+	assert.Equal(t, []byte{0, 0, 0}, password)
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
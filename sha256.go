@@ -0,0 +1,12 @@
+package httpdigest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+func sha256hex(format string, v ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf(format, v...)))
+	return hex.EncodeToString(sum[:])
+}
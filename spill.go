@@ -0,0 +1,123 @@
+package httpdigest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// spillBody reads b and returns two readers of the same bytes, closing b.
+// Up to limit bytes are buffered in memory, and larger bodies are copied to a
+// temporary file in dir, shared by the returned readers. A limit of zero or less keeps
+// the whole body in memory. getBody returns another copy of the body, like
+// http.Request.GetBody. The file is removed when all the copies are closed,
+// after which getBody fails.
+func spillBody(b io.ReadCloser, limit int64, dir string) (r1, r2 io.ReadCloser, getBody func() (io.ReadCloser, error), err error) {
+	if b == http.NoBody {
+		getBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return http.NoBody, http.NoBody, getBody, nil
+	}
+	var buf bytes.Buffer
+	if limit <= 0 {
+		_, err = buf.ReadFrom(b)
+	} else {
+		_, err = buf.ReadFrom(io.LimitReader(b, limit+1))
+	}
+	if err != nil {
+		return nil, b, nil, err
+	}
+	if limit <= 0 || int64(buf.Len()) <= limit {
+		if err = b.Close(); err != nil {
+			return nil, b, nil, err
+		}
+		data := buf.Bytes()
+		getBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+		r1, _ = getBody()
+		r2, _ = getBody()
+		return r1, r2, getBody, nil
+	}
+	f, err := ioutil.TempFile(dir, "httpdigest-body-")
+	if err != nil {
+		return nil, b, nil, err
+	}
+	size, err := io.Copy(f, io.MultiReader(&buf, b))
+	if err == nil {
+		err = b.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, b, nil, err
+	}
+	s := &spilledFile{f: f, size: size}
+	// a copy that is never sent is never closed either
+	runtime.SetFinalizer(s, (*spilledFile).remove)
+	r1, _ = s.reader()
+	r2, _ = s.reader()
+	return r1, r2, s.reader, nil
+}
+
+// bodyCopyKey is the context key of the getBody function of the copy of a
+// request body made by RoundTrip.
+type bodyCopyKey struct{}
+
+// spilledFile is a temporary file holding a request body.
+type spilledFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	refs    int
+	removed bool
+}
+
+// reader returns a new copy of the body, which holds a reference to the file
+// until it is closed.
+func (s *spilledFile) reader() (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed {
+		return nil, errors.New("the request body was already sent")
+	}
+	s.refs++
+	return &spilledReader{SectionReader: io.NewSectionReader(s.f, 0, s.size), file: s}, nil
+}
+
+// release drops a reference to the file, and removes it with the last one.
+func (s *spilledFile) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	s.removed = true
+	runtime.SetFinalizer(s, nil)
+	return s.remove()
+}
+
+func (s *spilledFile) remove() error {
+	err := s.f.Close()
+	if rerr := os.Remove(s.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+type spilledReader struct {
+	*io.SectionReader
+	file *spilledFile
+	once sync.Once
+}
+
+func (r *spilledReader) Close() error {
+	var err error
+	r.once.Do(func() { err = r.file.release() })
+	return err
+}
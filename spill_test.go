@@ -0,0 +1,149 @@
+package httpdigest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpillBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := func() int {
+		fis, _ := ioutil.ReadDir(dir)
+		return len(fis)
+	}
+
+	r1, r2, _, err := spillBody(ioutil.NopCloser(strings.NewReader("small")), 8, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, files())
+	b, _ := ioutil.ReadAll(r2)
+	assert.Equal(t, "small", string(b))
+
+	r1, r2, getBody, err := spillBody(ioutil.NopCloser(strings.NewReader("a larger body")), 8, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, files())
+	r3, err := getBody()
+	assert.NoError(t, err)
+	assert.NoError(t, r3.Close())
+	for _, r := range []io.ReadCloser{r1, r2} {
+		b, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "a larger body", string(b))
+	}
+	assert.NoError(t, r1.Close())
+	assert.NoError(t, r1.Close())
+	assert.Equal(t, 1, files())
+	assert.NoError(t, r2.Close())
+	assert.Equal(t, 0, files())
+	_, err = getBody()
+	assert.Error(t, err)
+}
+
+func TestTransportMaxBodyMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.MaxBodyMemory = 4
+	tr.BodyTempDir = dir
+	// no GetBody, so the body has to be copied
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/upload", ioutil.NopCloser(strings.NewReader("payload")))
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"payload", "payload"}, ts.bodies)
+}
+
+func TestTransportRequireGetBody(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.RequireGetBody = true
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, ioutil.NopCloser(strings.NewReader("payload")))
+	_, err := tr.RoundTrip(req)
+	assert.True(t, errors.Is(err, ErrBodyNotReplayable))
+	assert.Empty(t, ts.Requests())
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportAuthIntStreamedBody(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Qop = "auth-int"
+	dir, err := ioutil.TempDir("", "spill")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, limit := range []int64{0, 4} {
+		tr := New("john", "doe")
+		tr.MaxBodyMemory = limit
+		tr.BodyTempDir = dir
+		// no GetBody: the copy the transport keeps is hashed
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/upload", ioutil.NopCloser(strings.NewReader("payload")))
+		resp, err := tr.RoundTrip(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+	reqs := ts.Requests()
+	if assert.Len(t, reqs, 4) {
+		assert.Equal(t, "auth-int", parseDigest(reqs[3].Header.Get("Authorization"))["qop"])
+	}
+}
+
+func TestTransportSpilledBodyRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := func() int {
+		fis, _ := ioutil.ReadDir(dir)
+		return len(fis)
+	}
+	open := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer open.Close()
+	basic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="r"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer basic.Close()
+	ts := newTestServer(t)
+
+	for _, c := range []struct {
+		url    string
+		quirks Quirks
+		ok     bool
+	}{
+		// the probe is the request, the copy is not sent
+		{open.URL, Quirks{}, true},
+		// the handshake fails before the copy is sent
+		{basic.URL, Quirks{}, false},
+		// the probe is sent without the body
+		{ts.URL + "/upload", Quirks{ProbeMethod: http.MethodGet}, true},
+	} {
+		tr := New("john", "doe")
+		tr.MaxBodyMemory = 4
+		tr.BodyTempDir = dir
+		tr.Quirks = c.quirks
+		req, _ := http.NewRequest(http.MethodPost, c.url, ioutil.NopCloser(strings.NewReader("payload")))
+		resp, err := tr.RoundTrip(req)
+		if c.ok && assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+		assert.Equal(t, 0, files(), c.url)
+	}
+}
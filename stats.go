@@ -0,0 +1,38 @@
+package httpdigest
+
+import "sync"
+
+// Stats is a snapshot of the counters of a Transport.
+type Stats struct {
+	// Requests is the number of requests handled by RoundTrip.
+	Requests uint64
+	// Challenges is the number of challenges answered.
+	Challenges uint64
+	// Preemptive is the number of requests signed before the server asked
+	// for authentication (learned challenges and reused credentials).
+	Preemptive uint64
+	// PreemptiveRejected is the number of preemptively signed requests the
+	// server challenged anyway.
+	PreemptiveRejected uint64
+}
+
+// transportStats holds the counters of a Transport. The zero value is ready
+// to use.
+type transportStats struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+func (ts *transportStats) add(f func(s *Stats)) {
+	ts.mu.Lock()
+	f(&ts.s)
+	ts.mu.Unlock()
+}
+
+// Stats returns a snapshot of the counters of the transport. It is safe to
+// call at any time, concurrently with requests.
+func (t *Transport) Stats() Stats {
+	t.stats.mu.Lock()
+	defer t.stats.mu.Unlock()
+	return t.stats.s
+}
@@ -0,0 +1,65 @@
+package httpdigest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String describes the configuration of the transport without its secrets:
+// the password is masked, so logging the transport (even with %+v) never
+// leaks it.
+func (t *Transport) String() string {
+	creds := t.defaultCredentials()
+	var b strings.Builder
+	fmt.Fprintf(&b, "httpdigest.Transport{Username: %q, Password: %s", creds.Username, maskPassword(creds.Password))
+	if creds.HA1 != "" {
+		b.WriteString(", HA1: [redacted]")
+	}
+	if creds.Secret != nil {
+		b.WriteString(", Secret: [redacted]")
+	}
+	if t.DefaultAlgorithm != "" {
+		fmt.Fprintf(&b, ", DefaultAlgorithm: %q", t.DefaultAlgorithm)
+	}
+	if t.MinAlgorithm != "" {
+		fmt.Fprintf(&b, ", MinAlgorithm: %q", t.MinAlgorithm)
+	}
+	if len(t.AlgorithmPreference) > 0 {
+		fmt.Fprintf(&b, ", AlgorithmPreference: %q", t.AlgorithmPreference)
+	}
+	if len(t.SchemePreference) > 0 {
+		fmt.Fprintf(&b, ", SchemePreference: %q", t.SchemePreference)
+	}
+	if t.Jar != nil {
+		b.WriteString(", Jar: set")
+	}
+	s := t.Stats()
+	fmt.Fprintf(&b, ", Stats: {Requests: %d, Challenges: %d, Preemptive: %d, PreemptiveRejected: %d}}",
+		s.Requests, s.Challenges, s.Preemptive, s.PreemptiveRejected)
+	return b.String()
+}
+
+// GoString implements fmt.GoStringer so that %#v is masked as well.
+func (t *Transport) GoString() string {
+	return t.String()
+}
+
+// String masks the password.
+func (c Credentials) String() string {
+	if c.HA1 != "" {
+		return fmt.Sprintf("{Username: %q, Password: %s, HA1: [redacted]}", c.Username, maskPassword(c.Password))
+	}
+	return fmt.Sprintf("{Username: %q, Password: %s}", c.Username, maskPassword(c.Password))
+}
+
+// GoString implements fmt.GoStringer so that %#v is masked as well.
+func (c Credentials) GoString() string {
+	return "httpdigest.Credentials" + c.String()
+}
+
+func maskPassword(password string) string {
+	if password == "" {
+		return `""`
+	}
+	return "[redacted]"
+}
@@ -0,0 +1,24 @@
+package httpdigest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportString(t *testing.T) {
+	tr := New("john", "s3cret")
+	tr.DefaultAlgorithm = AlgorithmSHA256
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(verb, tr)
+		assert.NotContains(t, out, "s3cret", verb)
+		assert.Contains(t, out, `Username: "john"`, verb)
+	}
+	assert.Equal(t, `httpdigest.Transport{Username: "john", Password: [redacted], DefaultAlgorithm: "SHA-256", Stats: {Requests: 0, Challenges: 0, Preemptive: 0, PreemptiveRejected: 0}}`, tr.String())
+
+	creds := Credentials{Username: "john", Password: "s3cret"}
+	for _, verb := range []string{"%v", "%+v", "%#v"} {
+		assert.NotContains(t, fmt.Sprintf(verb, creds), "s3cret", verb)
+	}
+}
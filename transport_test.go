@@ -0,0 +1,999 @@
+package httpdigest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer is a minimal digest protected server (MD5, qop=auth) that
+// records the requests it receives.
+type testServer struct {
+	*httptest.Server
+	Realm    string
+	Nonce    string
+	Username string
+	Password string
+	// Qop is the qop offered in the challenge, "auth" if empty.
+	Qop string
+	// StrictNC rejects nonce-count values that don't increase.
+	StrictNC bool
+	// PublicHint serves /public without authentication, attaching the
+	// challenge to the response as a hint. These requests are not recorded.
+	PublicHint bool
+
+	mu       sync.Mutex
+	lastNC   map[string]string
+	requests []*http.Request
+	bodies   []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{
+		Realm:    "test-realm",
+		Nonce:    "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		Username: "john",
+		Password: "doe",
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.serveHTTP))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if ts.PublicHint && r.URL.Path == "/public" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop="auth",realm=%q,nonce=%q`, ts.Realm, ts.Nonce))
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	ts.mu.Lock()
+	ts.requests = append(ts.requests, r)
+	ts.bodies = append(ts.bodies, string(body))
+	ts.mu.Unlock()
+	if !ts.verify(r, body) {
+		qop := ts.Qop
+		if qop == "" {
+			qop = "auth"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop=%q,algorithm=MD5,realm=%q,nonce=%q`, qop, ts.Realm, ts.Nonce))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprintf(w, "hello %s", r.URL.Path)
+}
+
+func (ts *testServer) verify(r *http.Request, body []byte) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return false
+	}
+	p := parseDigest(auth)
+	if p["username"] != ts.Username || p["nonce"] != ts.Nonce || p["uri"] != r.URL.RequestURI() {
+		return false
+	}
+	ha1 := md5hex("%s:%s:%s", ts.Username, ts.Realm, ts.Password)
+	ha2 := md5hex("%s:%s", r.Method, p["uri"])
+	if p["qop"] == "auth-int" {
+		ha2 = md5hex("%s:%s:%s", r.Method, p["uri"], md5hex("%s", body))
+	}
+	if p["response"] != md5hex("%s:%s:%s:%s:%s:%s", ha1, ts.Nonce, p["nc"], p["cnonce"], p["qop"], ha2) {
+		return false
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.lastNC == nil {
+		ts.lastNC = make(map[string]string)
+	}
+	// nc values are fixed width hex, so they compare as strings
+	if ts.StrictNC && p["nc"] <= ts.lastNC[p["nonce"]] {
+		return false
+	}
+	ts.lastNC[p["nonce"]] = p["nc"]
+	return true
+}
+
+// Requests returns the requests received so far.
+func (ts *testServer) Requests() []*http.Request {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]*http.Request(nil), ts.requests...)
+}
+
+func TestTransportRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	cl, err := New("john", "doe").Client()
+	assert.NoError(t, err)
+	resp, err := cl.Post(ts.URL+"/json_rpc?x=1", "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, ts.Requests(), 2)
+	assert.Equal(t, []string{"payload", "payload"}, ts.bodies)
+}
+
+func TestTransportProbeMethod(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.Quirks.ProbeMethod = http.MethodHead
+	resp, err := (&http.Client{Transport: tr}).Post(ts.URL+"/upload", "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	assert.Len(t, reqs, 2)
+	assert.Equal(t, http.MethodHead, reqs[0].Method)
+	assert.Equal(t, http.MethodPost, reqs[1].Method)
+	assert.Equal(t, []string{"", "payload"}, ts.bodies)
+}
+
+func TestTransportHostQuirks(t *testing.T) {
+	tr := New("john", "doe")
+	tr.Quirks.Compact = true
+	tr.HostQuirks = map[string]Quirks{
+		"camera.local": {OmitQuery: true},
+	}
+	u, _ := http.NewRequest(http.MethodGet, "http://CAMERA.local:8080/a?b=c", nil)
+	q := tr.quirksFor(u.URL)
+	assert.True(t, q.OmitQuery)
+	assert.False(t, q.Compact)
+	assert.Equal(t, "/a", q.digestURI(u.URL))
+	u, _ = http.NewRequest(http.MethodGet, "http://other.local/a?b=c", nil)
+	assert.True(t, tr.quirksFor(u.URL).Compact)
+}
+
+func TestDo(t *testing.T) {
+	ts := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/hook", strings.NewReader("event"))
+	resp, err := Do(context.Background(), nil, req, Credentials{Username: "john", Password: "doe"})
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/hook", strings.NewReader("event"))
+	resp, err = Do(context.Background(), nil, req, Credentials{Username: "john", Password: "bad"})
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestPing(t *testing.T) {
+	ts := newTestServer(t)
+	assert.NoError(t, New("john", "doe").Ping(context.Background(), ts.URL))
+	assert.Equal(t, ErrBadCredentials, New("john", "bad").Ping(context.Background(), ts.URL))
+
+	unsupported := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Digest realm="r", nonce="n", qop="auth", algorithm=SHA-512`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unsupported.Close()
+	err := New("john", "doe").Ping(context.Background(), unsupported.URL)
+	assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm), err)
+
+	unsupported.Close()
+	err = New("john", "doe").Ping(context.Background(), unsupported.URL)
+	assert.True(t, errors.Is(err, ErrUnreachable), err)
+	assert.NotNil(t, errors.Unwrap(err))
+
+	// errors of the handshake are not reported as network errors
+	tr := New("john", "doe")
+	tr.MaxAuthAttempts = 1
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Digest realm="r", nonce="n", qop="auth", stale=true`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer stale.Close()
+	err = tr.Ping(context.Background(), stale.URL)
+	assert.True(t, errors.Is(err, ErrTooManyAttempts), err)
+	assert.False(t, errors.Is(err, ErrUnreachable), err)
+
+	errVault := errors.New("vault sealed")
+	tr = New("john", "doe")
+	tr.CredentialProvider = CredentialProviderFunc(func(ctx context.Context, host, realm string) (Credentials, error) {
+		return Credentials{}, errVault
+	})
+	err = tr.Ping(context.Background(), ts.URL)
+	assert.True(t, errors.Is(err, errVault), err)
+	assert.False(t, errors.Is(err, ErrUnreachable), err)
+
+	// the certificate of the server is not trusted
+	tlsts := httptest.NewUnstartedServer(ts.Config.Handler)
+	tlsts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	tlsts.StartTLS()
+	defer tlsts.Close()
+	err = New("john", "doe").Ping(context.Background(), tlsts.URL)
+	assert.True(t, errors.Is(err, ErrUnreachable), err)
+}
+
+func mustRequest(t *testing.T, method, url string) *http.Request {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestTransportAllowBasic(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); ok && u == "john" && p == "doe" {
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="device"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	ts.StartTLS()
+	defer ts.Close()
+
+	tr := New("john", "doe")
+	tr.Transport = ts.Client().Transport
+	_, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.True(t, errors.Is(err, ErrNoAcceptableScheme), err)
+
+	tr.AllowBasic = true
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// not over plain http, unless explicitly allowed
+	plain := httptest.NewServer(ts.Config.Handler)
+	defer plain.Close()
+	_, err = tr.RoundTrip(mustRequest(t, http.MethodGet, plain.URL))
+	assert.True(t, errors.Is(err, ErrNoAcceptableScheme), err)
+	tr.AllowInsecureBasic = true
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, plain.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportChallengeStatusCodes(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		if rec.Code == http.StatusUnauthorized {
+			rec.Code = http.StatusLocked
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+
+	tr := New("john", "doe")
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+	tr.ChallengeStatusCodes = []int{http.StatusLocked}
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportHeaderNames(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", r.Header.Get("X-Authorization"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if c := rec.Header().Get("WWW-Authenticate"); c != "" {
+			w.Header().Set("X-WWW-Authenticate", c)
+		}
+		w.WriteHeader(rec.Code)
+	})
+
+	tr := New("john", "doe")
+	tr.ChallengeHeader = "X-WWW-Authenticate"
+	tr.AuthorizationHeader = "X-Authorization"
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportCallerAuthorization(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Len(t, ts.Requests(), 1)
+
+	tr.OverwriteAuthorization = true
+	resp, err = tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+}
+
+func TestTransportAnonymous(t *testing.T) {
+	ts := newTestServer(t)
+	resp, err := New("", "").RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	reqs := ts.Requests()
+	assert.Len(t, reqs, 1)
+	assert.Empty(t, reqs[0].Header.Get("Authorization"))
+}
+
+func TestTransportLearnChallenges(t *testing.T) {
+	ts := newTestServer(t)
+	ts.PublicHint = true
+
+	tr := New("john", "doe")
+	tr.LearnChallenges = true
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/public"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/private"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// signed on the first attempt
+	assert.Len(t, ts.Requests(), 1)
+	assert.Equal(t, Stats{Requests: 2, Preemptive: 1}, tr.Stats())
+
+	ts.Nonce = "rotated"
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/private"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Stats{Requests: 3, Challenges: 1, Preemptive: 2, PreemptiveRejected: 1}, tr.Stats())
+}
+
+type closeIdleRecorder struct {
+	http.RoundTripper
+	closed int
+}
+
+func (r *closeIdleRecorder) CloseIdleConnections() {
+	r.closed++
+}
+
+func TestTransportCloseIdleConnections(t *testing.T) {
+	rec := &closeIdleRecorder{RoundTripper: http.DefaultTransport}
+	tr := New("john", "doe")
+	tr.Transport = rec
+	cl, err := tr.Client()
+	assert.NoError(t, err)
+	cl.CloseIdleConnections()
+	assert.Equal(t, 1, rec.closed)
+
+	// not supported by the underlying transport
+	tr.Transport = roundTripperFunc(http.DefaultTransport.RoundTrip)
+	assert.NotPanics(t, tr.CloseIdleConnections)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportSetTransport(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	assert.Equal(t, http.DefaultTransport, tr.UnderlyingTransport())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	rec := &closeIdleRecorder{RoundTripper: http.DefaultTransport}
+	tr.SetTransport(rec)
+	wg.Wait()
+	assert.Equal(t, rec, tr.UnderlyingTransport())
+}
+
+func TestTransportEnableCache(t *testing.T) {
+	ts := newTestServer(t)
+	ts.PublicHint = true
+	tr := New("john", "doe")
+	tr.LearnChallenges = true
+	for _, path := range []string{"/public", "/private"} {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+path))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, uint64(1), tr.Stats().Preemptive)
+
+	tr.EnableCache(false)
+	for _, path := range []string{"/public", "/private"} {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+path))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, uint64(1), tr.Stats().Preemptive)
+	assert.Equal(t, uint64(1), tr.Stats().Challenges)
+}
+
+func TestTransportSetCredentials(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "bad")
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	tr.SetCredentials("john", "doe")
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportNonceCount(t *testing.T) {
+	ts := newTestServer(t)
+	ts.StrictNC = true
+	ts.PublicHint = true
+	tr := New("john", "doe")
+	tr.LearnChallenges = true
+	for _, path := range []string{"/public", "/a", "/b", "/c"} {
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+path))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, Stats{Requests: 4, Preemptive: 3}, tr.Stats())
+	assert.Equal(t, "00000003", ts.lastNC[ts.Nonce])
+}
+
+func TestTransportSessionCnonce(t *testing.T) {
+	tr := New("john", "doe")
+	wwwa := &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth", Algorithm: AlgorithmMD5Sess}
+	req := mustRequest(t, http.MethodGet, "http://device.local/")
+	var cnonces []string
+	for i := 0; i < 3; i++ {
+		auth, err := tr.digest(req, wwwa, tr.credentials(req.URL), Quirks{})
+		assert.NoError(t, err)
+		p := parseDigest(auth)
+		cnonces = append(cnonces, p["cnonce"])
+		assert.Equal(t, fmt.Sprintf("%08x", i+1), p["nc"])
+	}
+	assert.Equal(t, cnonces[0], cnonces[1])
+	assert.Equal(t, cnonces[0], cnonces[2])
+}
+
+func TestTransportDefaultAlgorithm(t *testing.T) {
+	tr := New("john", "doe")
+	wwwa := &WWWAuth{Realm: "r", Nonce: "n", Qop: "auth"}
+	req := mustRequest(t, http.MethodGet, "http://device.local/")
+	auth, err := tr.digest(req, wwwa, tr.credentials(req.URL), Quirks{})
+	assert.NoError(t, err)
+	assert.Len(t, parseDigest(auth)["response"], 32)
+
+	tr.DefaultAlgorithm = AlgorithmSHA256
+	auth, err = tr.digest(req, wwwa, tr.credentials(req.URL), Quirks{})
+	assert.NoError(t, err)
+	assert.Equal(t, AlgorithmSHA256, parseDigest(auth)["algorithm"])
+	assert.Len(t, parseDigest(auth)["response"], 64)
+	assert.Empty(t, wwwa.Algorithm)
+
+	auth, err = tr.digest(req, wwwa, tr.credentials(req.URL), Quirks{DefaultAlgorithm: AlgorithmMD5})
+	assert.NoError(t, err)
+	assert.Equal(t, AlgorithmMD5, parseDigest(auth)["algorithm"])
+}
+
+func TestTransportPreflightHeader(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.PreflightHeader = http.Header{"User-Agent": {"probe/1.0"}}
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	req.Header.Set("User-Agent", "app/2.0")
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	assert.Equal(t, "probe/1.0", reqs[0].Header.Get("User-Agent"))
+	assert.Equal(t, "app/2.0", reqs[1].Header.Get("User-Agent"))
+	assert.Equal(t, "app/2.0", req.Header.Get("User-Agent"))
+}
+
+func TestTransportPreflightModifier(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.PreflightModifier = func(r *http.Request) {
+		r.Method = http.MethodHead
+		r.Header.Set("X-Trace", "probe")
+		r.Header.Del("X-Payload")
+	}
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	req.Header.Set("X-Payload", "1")
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	if assert.Len(t, reqs, 2) {
+		assert.Equal(t, http.MethodHead, reqs[0].Method)
+		assert.Equal(t, "probe", reqs[0].Header.Get("X-Trace"))
+		assert.Empty(t, reqs[0].Header.Get("X-Payload"))
+		assert.Equal(t, http.MethodGet, reqs[1].Method)
+		assert.Empty(t, reqs[1].Header.Get("X-Trace"))
+		assert.Equal(t, "1", reqs[1].Header.Get("X-Payload"))
+	}
+	assert.Equal(t, http.MethodGet, req.Method)
+}
+
+func TestTransportPreflightStripHeaders(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("X-Api-Key", "secret")
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	reqs := ts.Requests()
+	assert.Empty(t, reqs[0].Header.Get("Cookie"))
+	assert.Empty(t, reqs[0].Header.Get("X-Api-Key"))
+	assert.Equal(t, "session=1", reqs[1].Header.Get("Cookie"))
+	assert.Equal(t, "secret", reqs[1].Header.Get("X-Api-Key"))
+	assert.Equal(t, "session=1", req.Header.Get("Cookie"))
+
+	// the preemptive request is sent with every header
+	resp, err = tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	reqs = ts.Requests()
+	assert.Len(t, reqs, 3)
+	assert.Equal(t, "session=1", reqs[2].Header.Get("Cookie"))
+
+	tr = New("john", "doe")
+	tr.PreflightStripHeaders = []string{}
+	req = mustRequest(t, http.MethodGet, ts.URL)
+	req.Header.Set("Cookie", "session=1")
+	resp, err = tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	reqs = ts.Requests()
+	assert.Equal(t, "session=1", reqs[len(reqs)-2].Header.Get("Cookie"))
+}
+
+func TestTransportPreflightStripHeadersUnchallenged(t *testing.T) {
+	// an endpoint that doesn't use digest authentication
+	var seen []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("X-Api-Key"))
+		if r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("Cookie") != "session=1" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+	req := mustRequest(t, http.MethodGet, ts.URL)
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("X-Api-Key", "secret")
+	resp, err := New("john", "doe").RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	// the stripped probe isn't challenged, so the request is sent again as is
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"", "secret"}, seen)
+
+	// nothing to strip, the response to the first attempt is returned
+	seen = nil
+	resp, err = New("john", "doe").RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Len(t, seen, 1)
+}
+
+func TestTransportErrorWrapping(t *testing.T) {
+	tr := New("john", "doe")
+	tr.Transport = nil
+	_, err := tr.RoundTrip(mustRequest(t, http.MethodGet, "http://example.com"))
+	assert.True(t, errors.Is(err, ErrNoTransport))
+	_, err = tr.Client()
+	assert.True(t, errors.Is(err, ErrNoTransport))
+
+	errBody := errors.New("body gone")
+	tr = New("john", "doe")
+	req := mustRequest(t, http.MethodPost, "http://example.com")
+	req.Body = ioutil.NopCloser(strings.NewReader("x"))
+	req.GetBody = func() (io.ReadCloser, error) { return nil, errBody }
+	_, err = tr.RoundTrip(req)
+	assert.True(t, errors.Is(err, errBody))
+
+	ts := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL).WithContext(ctx))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+// closeRecorder is a request body that records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeRecorder) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTransportClosesBodyOnError(t *testing.T) {
+	post := func(url string) (*http.Request, *closeRecorder) {
+		body := &closeRecorder{Reader: strings.NewReader("x")}
+		req := mustRequest(t, http.MethodPost, url)
+		req.Body = body
+		return req, body
+	}
+
+	tr := New("john", "doe")
+	tr.Transport = nil
+	req, body := post("http://example.com")
+	_, err := tr.RoundTrip(req)
+	assert.True(t, errors.Is(err, ErrNoTransport))
+	assert.True(t, body.closed)
+
+	tr = New("john", "doe")
+	req, body = post("http://example.com")
+	req.GetBody = func() (io.ReadCloser, error) { return nil, errors.New("body gone") }
+	_, err = tr.RoundTrip(req)
+	assert.Error(t, err)
+	assert.True(t, body.closed)
+
+	// a request canceled while waiting for the handshake of another one
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	probed, proceed := make(chan struct{}), make(chan struct{})
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			close(probed)
+			<-proceed
+		}
+		base.ServeHTTP(w, r)
+	})
+	leader := make(chan struct{})
+	go func() {
+		defer close(leader)
+		resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}()
+	<-probed
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	req, body = post(ts.URL)
+	_, err = tr.RoundTrip(req.WithContext(ctx))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, body.closed)
+	close(proceed)
+	<-leader
+}
+
+func TestTransportQop(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Qop = "auth,auth-int"
+	qop := func(tr *Transport, req *http.Request) string {
+		resp, err := tr.RoundTrip(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		reqs := ts.Requests()
+		return parseDigest(reqs[len(reqs)-1].Header.Get("Authorization"))["qop"]
+	}
+	replayable := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/upload", strings.NewReader("payload"))
+		return req
+	}
+	streamed := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/upload", ioutil.NopCloser(strings.NewReader("payload")))
+		return req
+	}
+	tr := New("john", "doe")
+	assert.Equal(t, "auth-int", qop(tr, replayable()))
+	assert.Equal(t, "auth", qop(tr, streamed()))
+	assert.Equal(t, "auth", qop(tr, mustRequest(t, http.MethodGet, ts.URL)))
+	tr.Qop = "auth"
+	assert.Equal(t, "auth", qop(tr, replayable()))
+
+	ts.Qop = "auth-int"
+	tr = New("john", "doe")
+	assert.Equal(t, "auth-int", qop(tr, mustRequest(t, http.MethodGet, ts.URL)))
+	// the copy of the body is hashed
+	assert.Equal(t, "auth-int", qop(tr, streamed()))
+	tr.RequireGetBody = true
+	_, err := tr.RoundTrip(streamed())
+	assert.True(t, errors.Is(err, ErrBodyNotReplayable))
+}
+
+func TestTransportHandshakeTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	stall := make(chan struct{})
+	defer close(stall)
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stall" && r.Header.Get("Authorization") != "" {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		base.ServeHTTP(w, r)
+	})
+
+	tr := New("john", "doe")
+	tr.HandshakeTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/stall"))
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout))
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the body can be read after the timeout, once the handshake succeeded
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/ok"))
+	assert.NoError(t, err)
+	time.Sleep(2 * tr.HandshakeTimeout)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello /ok", string(body))
+	assert.NoError(t, resp.Body.Close())
+}
+
+func TestTransportAuthIntBodies(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Qop = "auth-int"
+	cl, err := New("john", "doe").Client()
+	assert.NoError(t, err)
+	for _, body := range []string{"", "payload"} {
+		resp, err := cl.Post(ts.URL+"/upload", "text/plain", strings.NewReader(body))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/upload", http.NoBody)
+	resp, err := cl.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, r := range ts.Requests() {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			assert.Equal(t, "auth-int", parseDigest(auth)["qop"])
+		}
+	}
+}
+
+func TestNewHA1(t *testing.T) {
+	ts := newTestServer(t)
+	tr := NewHA1("john", md5hex("john:%s:doe", ts.Realm))
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, tr.String(), md5hex("john:%s:doe", ts.Realm))
+}
+
+func TestTransportURLCredentials(t *testing.T) {
+	ts := newTestServer(t)
+	cl, err := New("", "").Client()
+	assert.NoError(t, err)
+	u := strings.Replace(ts.URL, "http://", "http://john:doe@", 1)
+	resp, err := cl.Get(u + "/path")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	if assert.Len(t, reqs, 2) {
+		assert.Empty(t, reqs[0].Header.Get("Authorization"))
+		assert.True(t, strings.HasPrefix(reqs[1].Header.Get("Authorization"), "Digest "))
+	}
+
+	// the userinfo beats the credentials of the transport
+	tr := New("jane", "wrong")
+	resp, err = tr.RoundTrip(mustRequest(t, http.MethodGet, u))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTransportStaleNonce(t *testing.T) {
+	ts := newTestServer(t)
+	rotated := false
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		// the nonce expires between the challenge and the authorized request
+		expire := r.Header.Get("Authorization") != "" && !rotated
+		if expire {
+			rotated, ts.Nonce = true, "rotated"
+		}
+		ts.mu.Unlock()
+		if expire {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop="auth",realm=%q,nonce="rotated",stale=true`, ts.Realm))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ts.serveHTTP(w, r)
+	})
+	resp, err := New("john", "doe").RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/status"))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	if assert.Len(t, reqs, 2) {
+		assert.Equal(t, "rotated", parseDigest(reqs[1].Header.Get("Authorization"))["nonce"])
+	}
+}
+
+func TestTransportMaxAuthAttempts(t *testing.T) {
+	var mu sync.Mutex
+	signed := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			mu.Lock()
+			signed++
+			mu.Unlock()
+		}
+		// the nonce is always reported stale
+		w.Header().Set("WWW-Authenticate", `Digest qop="auth",realm="r",nonce="n",stale=true`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	for _, max := range []int{0, 1, 5} {
+		mu.Lock()
+		signed = 0
+		mu.Unlock()
+		tr := New("john", "doe")
+		tr.MaxAuthAttempts = max
+		_, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+		assert.True(t, errors.Is(err, ErrTooManyAttempts))
+		if max == 0 {
+			max = DefaultMaxAuthAttempts
+		}
+		mu.Lock()
+		assert.Equal(t, max, signed)
+		mu.Unlock()
+	}
+}
+
+func TestTransportChallengeHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Digest qop="auth",realm="r",nonce="n"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"login required"}`)
+	}))
+	defer ts.Close()
+	var bodies []string
+	tr := New("john", "doe")
+	tr.ChallengeHook = func(resp *http.Response) {
+		b, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		bodies = append(bodies, string(b))
+	}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, []string{`{"error":"login required"}`}, bodies)
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func TestTransportExpectContinue(t *testing.T) {
+	ts := newTestServer(t)
+	base := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			// challenge without reading the body, so it is never sent
+			assert.Equal(t, "100-continue", r.Header.Get("Expect"))
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop="auth",realm=%q,nonce=%q`, ts.Realm, ts.Nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		base.ServeHTTP(w, r)
+	})
+	tr := New("john", "doe")
+	tr.ExpectContinue = true
+	tr.DisablePreemptive = true
+	body := &countingReader{Reader: strings.NewReader("payload")}
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload", body)
+	req.ContentLength = 7
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("payload")), nil
+	}
+	resp, err := tr.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&body.n))
+	assert.Equal(t, []string{"payload"}, ts.bodies)
+}
+
+func TestTransportCanceledMidHandshake(t *testing.T) {
+	ts := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := New("john", "doe")
+	tr.ChallengeHook = func(*http.Response) { cancel() }
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	_, err := tr.RoundTrip(req)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, ts.Requests(), 1)
+}
+
+func TestTransportClone(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.HostQuirks = map[string]Quirks{"camera.local": {ProbeMethod: http.MethodGet}}
+	tr.AllowedHosts = []string{"127.0.0.1"}
+	tr.PreflightHeader = http.Header{"User-Agent": {"probe"}}
+	tr.PreflightStripHeaders = []string{"Cookie"}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	tr2 := tr.Clone()
+	assert.Equal(t, tr.Transport, tr2.Transport)
+	assert.Equal(t, tr.HostQuirks, tr2.HostQuirks)
+	assert.Equal(t, []string{"Cookie"}, tr2.PreflightStripHeaders)
+	assert.Equal(t, Stats{}, tr2.Stats())
+	tr2.SetCredentials("jane", "roe")
+	tr2.HostQuirks["other.local"] = Quirks{ForceMD5: true}
+	tr2.AllowedHosts[0] = "other.local"
+	tr2.PreflightHeader.Set("User-Agent", "other")
+	assert.Equal(t, "john", tr.Username)
+	assert.Len(t, tr.HostQuirks, 1)
+	assert.Equal(t, []string{"127.0.0.1"}, tr.AllowedHosts)
+	assert.Equal(t, "probe", tr.PreflightHeader.Get("User-Agent"))
+}
+
+func TestTransportDigestParams(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	tr.DigestParams = func(req *http.Request, challenge *WWWAuth) map[string]string {
+		return map[string]string{"device": req.URL.Path, "realm-seen": challenge.Params["realm"]}
+	}
+	resp, err := tr.RoundTrip(mustRequest(t, http.MethodGet, ts.URL+"/cam/1"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reqs := ts.Requests()
+	p := parseDigest(reqs[len(reqs)-1].Header.Get("Authorization"))
+	assert.Equal(t, "/cam/1", p["device"])
+	assert.Equal(t, ts.Realm, p["realm-seen"])
+}
@@ -0,0 +1,60 @@
+package httpdigest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/></D:prop></D:propfind>`
+
+func TestWebDAVMethods(t *testing.T) {
+	ts := newTestServer(t)
+	tr := New("john", "doe")
+	// every method goes through the whole handshake
+	tr.DisablePreemptive = true
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		header map[string]string
+	}{
+		{method: "PROPFIND", path: "/dav/", body: propfindBody, header: map[string]string{"Depth": "1"}},
+		{method: "MKCOL", path: "/dav/new%20folder/"},
+		{method: "MOVE", path: "/dav/a.txt", header: map[string]string{"Destination": ts.URL + "/dav/b.txt", "Overwrite": "F"}},
+		{method: "COPY", path: "/dav/b.txt", header: map[string]string{"Destination": ts.URL + "/dav/c.txt"}},
+		{method: "PROPPATCH", path: "/dav/c.txt", body: propfindBody},
+		{method: "LOCK", path: "/dav/c.txt", body: propfindBody, header: map[string]string{"Timeout": "Second-60"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			n := len(ts.Requests())
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			assert.NoError(t, err)
+			// force the path without GetBody
+			req.GetBody = nil
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := tr.RoundTrip(req)
+			assert.NoError(t, err)
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+			reqs := ts.Requests()[n:]
+			assert.Len(t, reqs, 2)
+			for i, r := range reqs {
+				assert.Equal(t, tt.method, r.Method)
+				assert.Equal(t, tt.body, ts.bodies[n+i])
+				for k, v := range tt.header {
+					assert.Equal(t, v, r.Header.Get(k))
+				}
+			}
+			assert.Empty(t, req.Header.Get("Authorization"))
+		})
+	}
+}